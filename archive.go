@@ -0,0 +1,148 @@
+// 18 october 2026
+package main
+
+import (
+	"fmt"
+	"os"
+	"io"
+	"path/filepath"
+	"strings"
+	"archive/zip"
+)
+
+// an archive is anything we can pull ROMs out of
+type archive interface {
+	Files() []archiveFile
+	Close() error
+}
+
+type archiveFile interface {
+	Name() string
+	Size() uint64
+	CRC32() (uint32, error)
+	Sums() (*cacheSum, error)		// hashes the whole file unless cached
+	Open() (io.ReadCloser, error)
+}
+
+// where a particular ROM can be found
+type romLocation struct {
+	archive	string		// filename of the archive (or loose set directory)
+	entry	string		// name of the file within it
+}
+
+// the kinds of archive a ROM set can come in, in the order we look for them (which is also the order MAME uses)
+// "" is a loose set in a directory
+var archiveExts = []string{".zip", ".7z", ""}
+
+// the returned error satisfies os.IsNotExist() if the archive isn't there
+// loose sets are told apart by being directories and not by their lack of an extension, since set names can have dots in them too
+func openArchive(filename string) (archive, error) {
+	if isLooseSet(filename) {
+		return openLooseDir(filename)
+	}
+	switch strings.ToLower(filepath.Ext(filename)) {		// with -normalize case, G1.ZIP is fine
+	case ".zip":
+		return openZip(filename)
+	case ".7z":
+		return open7z(filename)
+	}
+	if _, err := os.Stat(filename); err != nil {
+		return nil, err
+	}
+	return nil, fmt.Errorf("unknown archive type %q", filepath.Ext(filename))
+}
+
+// open the archive and find the given entry in it; the caller closes the archive
+func (l romLocation) open() (archive, archiveFile, error) {
+	a, err := openArchive(l.archive)
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, f := range a.Files() {
+		if f.Name() == l.entry {
+			return a, f, nil
+		}
+	}
+	a.Close()
+	return nil, nil, fmt.Errorf("%s has disappeared from %s", l.entry, l.archive)
+}
+
+// get the sums of a file, from the verification cache if possible
+// crc is the CRC-32 the archive itself records, or nil if it doesn't have one
+func cachedSums(path string, fi os.FileInfo, entry string, size int64, crc *uint32, open func() (io.ReadCloser, error)) (*cacheSum, error) {
+	sum := cacheLookup(path, fi, entry)
+	if sum != nil && (crc == nil || sum.CRC32 == *crc) {		// a CRC-32 mismatch means the entry changed without the archive changing, somehow
+		return sum, nil
+	}
+	r, err := open()
+	if err != nil {
+		return nil, fmt.Errorf("could not open given archive entry: %v", err)
+	}
+	sum, err = hashData(r, size)
+	cerr := r.Close()		// for 7z, this is where we find out it failed, and what it gave us can't be trusted
+	if err == io.ErrUnexpectedEOF {
+		return nil, fmt.Errorf("short read from archive or write to hash but no error returned (expected %d bytes)", size)
+	} else if err != nil {
+		return nil, fmt.Errorf("could not read given archive entry: %v", err)
+	}
+	if cerr != nil {
+		return nil, fmt.Errorf("could not read given archive entry: %v", cerr)
+	}
+	cacheStore(path, fi, entry, sum)
+	return sum, nil
+}
+
+type zipArchive struct {
+	*zip.ReadCloser
+	filename	string
+	fi		os.FileInfo
+	files		[]archiveFile
+}
+
+type zipFile struct {
+	a	*zipArchive
+	*zip.File
+}
+
+func openZip(filename string) (*zipArchive, error) {
+	fi, err := os.Stat(filename)
+	if err != nil {
+		return nil, err
+	}
+	f, err := zip.OpenReader(filename)
+	if err != nil {
+		return nil, err
+	}
+	a := &zipArchive{
+		ReadCloser:	f,
+		filename:		filename,
+		fi:			fi,
+	}
+	for _, file := range f.File {
+		a.files = append(a.files, &zipFile{
+			a:		a,
+			File:		file,
+		})
+	}
+	return a, nil
+}
+
+func (a *zipArchive) Files() []archiveFile {
+	return a.files
+}
+
+func (f *zipFile) Name() string {
+	return f.File.Name
+}
+
+func (f *zipFile) Size() uint64 {
+	return f.UncompressedSize64		// archive/zip fills this in from the Zip64 extra field if there is one
+}
+
+func (f *zipFile) CRC32() (uint32, error) {
+	return f.File.CRC32, nil
+}
+
+func (f *zipFile) Sums() (*cacheSum, error) {
+	return cachedSums(f.a.filename, f.a.fi, f.File.Name, int64(f.UncompressedSize64), &f.File.CRC32, f.File.Open)
+}
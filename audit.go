@@ -0,0 +1,214 @@
+// 18 october 2026
+package main
+
+import (
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+)
+
+// what we know about a single ROM or CHD after looking for it
+// ordered from worst to best so that checking several candidates can just keep the highest
+type auditState int
+
+const (
+	stateMissing auditState = iota
+	stateWrongSize
+	stateWrongCRC
+	stateWrongSHA1
+	stateWrongMD5		// a CHD checked against the MD5 old XML files give instead
+	stateUnverifiable	// a CHD too old to have a SHA-1
+	stateNoParent		// a CHD whose parent CHD (or its parent, etc.) can't be found
+	stateBadDump		// found, but the dump itself is known to be bad
+	stateOK
+	stateNoDump		// never dumped, so there's nothing to find
+)
+
+var stateNames = map[auditState]string{
+	stateMissing:		"missing",
+	stateWrongSize:	"wrong size",
+	stateWrongCRC:	"wrong CRC-32",
+	stateWrongSHA1:	"wrong SHA-1",
+	stateWrongMD5:	"wrong MD5",
+	stateUnverifiable:	"unverifiable",
+	stateNoParent:		"parent CHD missing",
+	stateBadDump:		"bad dump",
+	stateOK:			"ok",
+	stateNoDump:		"no dump",
+}
+
+func (s auditState) String() string {
+	return stateNames[s]
+}
+
+// kept in each ROM and CHD; prepared by Game.Find()
+type ItemAudit struct {
+	State	auditState
+	Archive	string		// the archive (or CHD file) with the best candidate, if any
+	Via		string		// the parent it was found in, if it wasn't found in this game's own set
+	Optional	bool			// if so, missing is fine
+}
+
+func (a *ItemAudit) reset(status string, optional bool) {
+	*a = ItemAudit{
+		Optional:	optional,
+	}
+	if status == nodump {
+		a.State = stateNoDump
+	}
+}
+
+// remember a candidate if it's better than what we had
+func (a *ItemAudit) note(state auditState, archive string) {
+	if state > a.State {
+		a.State = state
+		a.Archive = archive
+		a.Via = ""
+	}
+}
+
+func (a *ItemAudit) good() bool {
+	return a.State == stateOK || a.State == stateBadDump || a.State == stateNoDump || (a.Optional && a.State == stateMissing)
+}
+
+func (a *ItemAudit) String() string {
+	s := a.State.String()
+	if a.Optional {
+		s += " (optional)"
+	}
+	if a.Archive != "" {
+		s += fmt.Sprintf(" (in %s of %s", filepath.Base(a.Archive), filepath.Dir(a.Archive))
+		if a.Via != "" {
+			s += ", from parent " + a.Via
+		}
+		s += ")"
+	}
+	return s
+}
+
+// what we know about a game as a whole; prepared by Game.Find()
+type Audit struct {
+	Parents	[]string		// the parent chain consulted, nearest first
+	Devices	[]string		// the devices consulted
+	BIOS		[]BIOSAudit	// which of the machine's BIOS options can be used
+	Problems	[]string		// things wrong with the set as a whole
+	Normalized	[]string		// names that only matched once normalized (see -normalize)
+}
+
+func (g *Game) resetAudit() {
+	g.Audit = &Audit{}
+	for _, a := range g.ancestors()[1:] {
+		g.Audit.Parents = append(g.Audit.Parents, a.Name)
+	}
+	for i := range g.ROMs {
+		g.ROMs[i].Audit.reset(g.ROMs[i].Status, g.ROMs[i].isOptional())
+	}
+	for i := range g.CHDs {
+		g.CHDs[i].Audit.reset(g.CHDs[i].Status, g.CHDs[i].Optional == yes)
+	}
+}
+
+func (g *Game) problem(format string, args ...interface{}) {
+	g.Audit.Problems = append(g.Audit.Problems, fmt.Sprintf(format, args...))
+}
+
+type BIOSAudit struct {
+	BIOSSet
+	Usable	bool
+}
+
+// a BIOS option is usable if all of its ROMs are
+func (g *Game) auditBIOS() {
+	for _, b := range g.biosSets() {
+		ba := BIOSAudit{
+			BIOSSet:	b,
+			Usable:	true,
+		}
+		for _, r := range g.ROMs {
+			if r.BIOS == b.Name && !(r.Audit.State >= stateBadDump) {
+				ba.Usable = false
+			}
+		}
+		g.Audit.BIOS = append(g.Audit.BIOS, ba)
+	}
+}
+
+// the ROMs and CHDs that weren't found, in a form suitable for the log
+func (g *Game) auditSummary() string {
+	var bad []string
+	for _, r := range g.ROMs {
+		if !r.Audit.good() {
+			bad = append(bad, fmt.Sprintf("rom %s: %v", r.Name, &r.Audit))
+		}
+	}
+	for _, c := range g.CHDs {
+		if !c.Audit.good() {
+			bad = append(bad, fmt.Sprintf("disk %s: %v", c.Name, &c.Audit))
+		}
+	}
+	bad = append(bad, g.Audit.Problems...)
+	return strings.Join(bad, "; ")
+}
+
+func (g *Game) writeReport(w io.Writer) {
+	if g.Found {
+		where := g.ROMLoc
+		if g.Synth != nil {
+			where = "synthesized zip"
+		} else if where == "" {
+			where = "no ROMs needed"
+		}
+		fmt.Fprintf(w, "%s: good (%s)\n", g.Name, where)
+	} else {
+		fmt.Fprintf(w, "%s: bad\n", g.Name)
+	}
+	if g.Audit == nil {		// found as part of an earlier run
+		return
+	}
+	if len(g.Audit.Parents) != 0 {
+		fmt.Fprintf(w, "\tparents: %s\n", strings.Join(g.Audit.Parents, ", "))
+	}
+	if len(g.Audit.Devices) != 0 {
+		fmt.Fprintf(w, "\tdevices: %s\n", strings.Join(g.Audit.Devices, ", "))
+	}
+	for _, p := range g.Audit.Problems {
+		fmt.Fprintf(w, "\tproblem: %s\n", p)
+	}
+	for _, n := range g.Audit.Normalized {
+		fmt.Fprintf(w, "\tnormalized: %s\n", n)
+	}
+	for _, b := range g.Audit.BIOS {
+		usable := "usable"
+		if !b.Usable {
+			usable = "not usable"
+		}
+		if b.Name == g.defaultBIOS() {
+			usable += ", default"
+		}
+		fmt.Fprintf(w, "\tbios %s (%s): %s\n", b.Name, b.Description, usable)
+	}
+	for _, r := range g.ROMs {
+		fmt.Fprintf(w, "\trom %s: %v\n", r.Name, &r.Audit)
+	}
+	for _, c := range g.CHDs {
+		fmt.Fprintf(w, "\tdisk %s: %v\n", c.Name, &c.Audit)
+	}
+	if name := g.sampleSet(); name != "" && len(sampleDirs) != 0 {
+		s := sampleSets[name]
+		found, err := s.Find()
+		switch {
+		case err != nil:
+			fmt.Fprintf(w, "\tsamples %s: error: %v\n", name, err)
+		case found:
+			fmt.Fprintf(w, "\tsamples %s: ok (%s)\n", name, s.Loc)
+		case s.Missing != nil:
+			fmt.Fprintf(w, "\tsamples %s: missing %s\n", name, strings.Join(s.Missing, ", "))
+		default:
+			fmt.Fprintf(w, "\tsamples %s: missing\n", name)
+		}
+	}
+	for _, e := range g.Extras {
+		fmt.Fprintf(w, "\textra: %s\n", e)
+	}
+}
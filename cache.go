@@ -0,0 +1,154 @@
+// 18 october 2026
+package main
+
+import (
+	"os"
+	"io"
+	"sync"
+	"syscall"
+	"time"
+	"hash/crc32"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/gob"
+	"path/filepath"
+	"log"
+)
+
+// the verification cache remembers the CRC-32 and SHA-1 sums of everything we hash so a remount does not have to hash every archive again
+// records are keyed by path and thrown away as soon as the size, modification time, or inode of the file on disk changes
+
+type cacheSum struct {
+	CRC32	uint32
+	SHA1		string		// hex, like in the MAME XML file
+}
+
+type cacheFile struct {
+	Size		int64
+	ModTime	int64			// nanoseconds since the epoch
+	Inode	uint64
+	Entries	map[string]*cacheSum	// archive entry name ("" for a CHD) -> sums
+}
+
+var cacheFilename string
+var verifyCache = map[string]*cacheFile{}
+var cacheLock sync.Mutex
+var cacheDirty bool
+
+const cacheFlushInterval = time.Minute
+
+func inode(fi os.FileInfo) uint64 {
+	if st, ok := fi.Sys().(*syscall.Stat_t); ok {
+		return uint64(st.Ino)
+	}
+	return 0
+}
+
+// get the record for the given file, starting a fresh one if the old one is stale
+// cacheLock must be held
+func cacheRecord(path string, fi os.FileInfo) *cacheFile {
+	c, ok := verifyCache[path]
+	if ok && c.Size == fi.Size() && c.ModTime == fi.ModTime().UnixNano() && c.Inode == inode(fi) {
+		return c
+	}
+	c = &cacheFile{
+		Size:		fi.Size(),
+		ModTime:	fi.ModTime().UnixNano(),
+		Inode:	inode(fi),
+		Entries:	map[string]*cacheSum{},
+	}
+	verifyCache[path] = c
+	cacheDirty = true
+	return c
+}
+
+// returns nil if there is no (valid) cached sum
+func cacheLookup(path string, fi os.FileInfo, entry string) *cacheSum {
+	if cacheFilename == "" {
+		return nil
+	}
+	cacheLock.Lock()
+	defer cacheLock.Unlock()
+	return cacheRecord(path, fi).Entries[entry]
+}
+
+func cacheStore(path string, fi os.FileInfo, entry string, sum *cacheSum) {
+	if cacheFilename == "" {
+		return
+	}
+	cacheLock.Lock()
+	defer cacheLock.Unlock()
+	cacheRecord(path, fi).Entries[entry] = sum
+	cacheDirty = true
+}
+
+// hash the given data, returning the sums in the form the cache stores them
+func hashData(r io.Reader, size int64) (*cacheSum, error) {
+	crc := crc32.NewIEEE()
+	sha := sha1.New()
+	n, err := io.Copy(io.MultiWriter(crc, sha), r)
+	if err != nil {
+		return nil, err
+	}
+	if n != size {
+		return nil, io.ErrUnexpectedEOF
+	}
+	return &cacheSum{
+		CRC32:	crc.Sum32(),
+		SHA1:	hex.EncodeToString(sha.Sum(nil)),
+	}, nil
+}
+
+func loadCache(filename string) {
+	cacheFilename = filename
+	f, err := os.Open(filename)
+	if os.IsNotExist(err) {		// first run; we'll make it when we save
+		return
+	} else if err != nil {
+		log.Fatalf("could not open verification cache %s: %v", filename, err)
+	}
+	defer f.Close()
+	err = gob.NewDecoder(f).Decode(&verifyCache)
+	if err != nil {		// not fatal; we'll just rehash everything
+		log.Printf("could not read verification cache %s; starting over: %v\n", filename, err)
+		verifyCache = map[string]*cacheFile{}
+	}
+}
+
+func saveCache() {
+	if cacheFilename == "" {
+		return
+	}
+	cacheLock.Lock()
+	defer cacheLock.Unlock()
+	if !cacheDirty {
+		return
+	}
+	// write to a temporary file first so a crash halfway through doesn't lose the old cache
+	f, err := os.CreateTemp(filepath.Dir(cacheFilename), filepath.Base(cacheFilename) + ".*")
+	if err != nil {
+		log.Printf("could not create temporary file to save verification cache: %v\n", err)
+		return
+	}
+	err = gob.NewEncoder(f).Encode(verifyCache)
+	if err == nil {
+		err = f.Close()
+	} else {
+		f.Close()
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), cacheFilename)
+	}
+	if err != nil {
+		log.Printf("could not save verification cache %s: %v\n", cacheFilename, err)
+		os.Remove(f.Name())
+		return
+	}
+	cacheDirty = false
+}
+
+func cacheFlusher() {
+	for range time.Tick(cacheFlushInterval) {
+		saveCache()
+	}
+}
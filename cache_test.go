@@ -0,0 +1,119 @@
+// 18 october 2026
+package main
+
+import (
+	"testing"
+	"os"
+	"io"
+	"strings"
+	"time"
+	"path/filepath"
+)
+
+// start with an empty cache saved to a temporary file, as if -cache were given
+func testCache(t *testing.T) {
+	cacheFilename = filepath.Join(t.TempDir(), "cache")
+	verifyCache = map[string]*cacheFile{}
+	t.Cleanup(func() {
+		cacheFilename = ""
+		verifyCache = map[string]*cacheFile{}
+	})
+}
+
+// the sums of filename, and whether they had to be worked out again
+func testSums(t *testing.T, filename string) (*cacheSum, bool) {
+	fi, err := os.Stat(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hashed := false
+	sum, err := cachedSums(filename, fi, "", fi.Size(), nil, func() (io.ReadCloser, error) {
+		hashed = true
+		return os.Open(filename)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sum, hashed
+}
+
+func TestCache(t *testing.T) {
+	testCache(t)
+	filename := filepath.Join(t.TempDir(), "rom.bin")
+	err := os.WriteFile(filename, []byte("some ROM"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sum, hashed := testSums(t, filename)
+	if !hashed {
+		t.Fatal("first look at a file didn't hash it")
+	}
+	if sum.CRC32 != 0x6f3daaa8 || sum.SHA1 != "5bbd60e7853c0ce36ddeace022b1511e8fdaf66f" {
+		t.Errorf("sums are %08x %s", sum.CRC32, sum.SHA1)
+	}
+	if again, hashed := testSums(t, filename); hashed || *again != *sum {
+		t.Errorf("unchanged file hashed again (%v) or gave different sums", hashed)
+	}
+
+	// a remount reads the cache back from disk
+	saveCache()
+	verifyCache = map[string]*cacheFile{}
+	loadCache(cacheFilename)
+	if again, hashed := testSums(t, filename); hashed || *again != *sum {
+		t.Errorf("unchanged file hashed again (%v) or gave different sums after reloading the cache", hashed)
+	}
+
+	// touching the file throws its sums away
+	fi, _ := os.Stat(filename)
+	later := fi.ModTime().Add(time.Second)
+	err = os.Chtimes(filename, later, later)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, hashed := testSums(t, filename); !hashed {
+		t.Errorf("file not hashed again after its modification time changed")
+	}
+
+	// and so does putting another file of the same size with the same modification time in its place
+	other := filename + ".new"
+	err = os.WriteFile(other, []byte("some RAM"), 0644)
+	if err == nil {
+		err = os.Chtimes(other, later, later)
+	}
+	if err == nil {
+		err = os.Rename(other, filename)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	if changed, hashed := testSums(t, filename); !hashed || changed.SHA1 == sum.SHA1 {
+		t.Errorf("file with a new inode not hashed again (%v)", hashed)
+	}
+}
+
+// an archive's own CRC-32 overrules the cache
+func TestCacheCRCMismatch(t *testing.T) {
+	testCache(t)
+	filename := filepath.Join(t.TempDir(), "rom.bin")
+	err := os.WriteFile(filename, []byte("some ROM"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fi, _ := os.Stat(filename)
+	cacheStore(filename, fi, "entry", &cacheSum{
+		CRC32:	0x12345678,
+		SHA1:	strings.Repeat("0", 40),
+	})
+	hashed := false
+	crc := uint32(0x87654321)
+	_, err = cachedSums(filename, fi, "entry", fi.Size(), &crc, func() (io.ReadCloser, error) {
+		hashed = true
+		return os.Open(filename)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !hashed {
+		t.Errorf("cached sums used even though the archive's CRC-32 doesn't match them")
+	}
+}
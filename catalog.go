@@ -0,0 +1,95 @@
+// 18 october 2026
+package main
+
+import (
+	"fmt"
+	"io"
+	"bufio"
+	"encoding/xml"
+	"strings"
+	"code.google.com/p/rsc/fuse"
+	"log"
+)
+
+// the file given as mamexml can be any of several kinds of catalog, told apart by their root elements
+type catalogLoader interface {
+	// root has already been read; load reads everything up to the end of it
+	load(d *xml.Decoder, root xml.StartElement, filename string, fstree *fuse.Tree) error
+}
+
+var catalogLoaders = map[string]catalogLoader{
+	"mame":			listXMLLoader{},
+	"mess":			listXMLLoader{},
+	"softwarelist":		softwareListLoader{},
+	"softwarelists":	softwareListsLoader{},
+	"datafile":			logiqxLoader{},
+}
+
+// ClrMamePro DATs aren't XML at all, so they're told apart before even that
+func readCatalog(r io.Reader, filename string, fstree *fuse.Tree) {
+	br := bufio.NewReader(r)
+	start, _ := br.Peek(512)
+	if isCMPDat(start) {
+		err := readCMPDat(br)
+		if err != nil {
+			log.Fatalf("error reading ClrMamePro DAT file %s: %v", filename, err)
+		}
+		return
+	}
+	d := xml.NewDecoder(br)
+	root, err := xmlRoot(d)
+	if err != nil {
+		log.Fatalf("error finding root element of MAME XML file %s: %v", filename, err)
+	}
+	l, ok := catalogLoaders[strings.ToLower(root.Name.Local)]
+	if !ok {
+		log.Fatalf("%s is not a MAME XML file or DAT file (root element is <%s>)", filename, root.Name.Local)
+	}
+	err = l.load(d, root, filename, fstree)
+	if err != nil {
+		log.Fatalf("error reading %s: %v", filename, err)
+	}
+}
+
+// the start of the file without any byte order mark or leading whitespace
+func catalogStart(b []byte) string {
+	return strings.TrimLeft(strings.TrimPrefix(string(b), "\ufeff"), " \t\r\n")
+}
+
+func isXML(b []byte) bool {
+	return strings.HasPrefix(catalogStart(b), "<")
+}
+
+// skip the XML declaration, DOCTYPE, and so on
+func xmlRoot(d *xml.Decoder) (xml.StartElement, error) {
+	for {
+		t, err := d.Token()
+		if err != nil {
+			return xml.StartElement{}, err
+		}
+		if e, ok := t.(xml.StartElement); ok {
+			return e, nil
+		}
+	}
+}
+
+// call f on each child of the element we're in until it ends; f must consume the child
+func eachChild(d *xml.Decoder, f func(e xml.StartElement) error) error {
+	for {
+		t, err := d.Token()
+		if err == io.EOF {
+			return fmt.Errorf("XML ends early")
+		} else if err != nil {
+			return err
+		}
+		switch e := t.(type) {
+		case xml.StartElement:
+			err = f(e)
+			if err != nil {
+				return err
+			}
+		case xml.EndElement:
+			return nil
+		}
+	}
+}
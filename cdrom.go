@@ -0,0 +1,72 @@
+// 18 october 2026
+package main
+
+// mode 1 and mode 2 form 1 CD sectors end in Reed-Solomon product code ECC (P and Q parity), which CHD drops when it can be worked out from the data again
+// this regenerates it the same way MAME's cdrom.cpp does
+
+var cdSyncHeader = [12]byte{0x00, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0x00}
+
+const (
+	cdModeOffset		= 15
+	cdSyncBytes		= 12
+	eccPOffset		= 0x81C
+	eccPNumBytes		= 86
+	eccPComponents	= 24
+	eccQOffset		= eccPOffset + 2 * eccPNumBytes
+	eccQNumBytes		= 52
+	eccQComponents	= 43
+)
+
+// multiplication by 2 in GF(2^8), and its inverse after adding one
+var eccLow, eccHigh [256]byte
+
+func init() {
+	for i := 0; i < 256; i++ {
+		j := i << 1
+		if i & 0x80 != 0 {
+			j ^= 0x11D
+		}
+		eccLow[i] = byte(j)
+		eccHigh[byte(i ^ j)] = byte(i)
+	}
+}
+
+// P vectors run down the columns of the sector laid out as 16-bit words 43 wide; Q vectors run along the diagonals
+func eccPOffsetOf(b int, c int) int {
+	return b + eccPNumBytes * c
+}
+
+func eccQOffsetOf(b int, c int) int {
+	return 2 * (((b >> 1) * 43 + c * 44) % 1118) + b & 1
+}
+
+// mode 2 sectors use zero for the header
+func eccSourceByte(sector []byte, offset int) byte {
+	if sector[cdModeOffset] == 1 || offset >= 4 {
+		return sector[cdSyncBytes + offset]
+	}
+	return 0
+}
+
+func eccComputeBytes(sector []byte, n int, components int, offsetOf func(int, int) int) (byte, byte) {
+	var v1, v2 byte
+	for c := 0; c < components; c++ {
+		s := eccSourceByte(sector, offsetOf(n, c))
+		v1 ^= s
+		v2 ^= s
+		v1 = eccLow[v1]
+	}
+	v1 = eccHigh[eccLow[v1] ^ v2]
+	v2 ^= v1
+	return v1, v2
+}
+
+// like MAME, this doesn't look at the mode: mode 2 form 1 sectors have the same ECC (see eccSourceByte()), and CHD only asks for it on sectors it was stripped from
+func eccGenerate(sector []byte) {
+	for b := 0; b < eccPNumBytes; b++ {
+		sector[eccPOffset + b], sector[eccPOffset + eccPNumBytes + b] = eccComputeBytes(sector, b, eccPComponents, eccPOffsetOf)
+	}
+	for b := 0; b < eccQNumBytes; b++ {
+		sector[eccQOffset + b], sector[eccQOffset + eccQNumBytes + b] = eccComputeBytes(sector, b, eccQComponents, eccQOffsetOf)
+	}
+}
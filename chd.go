@@ -0,0 +1,649 @@
+// 18 october 2026
+package main
+
+import (
+	"fmt"
+	"os"
+	"io"
+	"sort"
+	"bytes"
+	"strings"
+	"hash/crc32"
+	"crypto/sha1"
+	"encoding/binary"
+	"encoding/hex"
+)
+
+// sha1check_chd() only trusts the SHA-1 the CHD says it has; this reads the whole thing back so a CHD with damaged hunks can be caught
+// only v3 through v5 can be decoded; v1 and v2 predate SHA-1 entirely (and MAME no longer reads them), so all we do with those is read the header
+// TODO no zstd (zstd, cdzs) or A/V (avhu, v3/v4 compression 3) codecs
+
+type chdHeader struct {
+	Version		uint32
+	HeaderLen		uint32
+	Flags		uint32		// v1 to v4
+	HunkBytes		uint32
+	UnitBytes		uint32
+	TotalHunks	uint32
+	LogicalBytes	uint64
+	MapOffset		uint64		// v5; v3 and v4 maps come right after the header
+	MetaOffset		uint64
+	Compressors	[4]uint32		// v5 codec tags; for v3 and v4 only [0] is used and is the old compression type
+	RawSHA1		[20]byte		// of the data alone; same as SHA1 for v3
+	SHA1			[20]byte		// of the data and the checksummed metadata; this is what the MAME XML file has
+	ParentSHA1		[20]byte		// zero if there is no parent
+	MD5			[16]byte		// v1 to v3
+	ParentMD5		[16]byte
+}
+
+// the kinds of hunk, v3/v4 and v5 combined
+const (
+	chdHunkCompressed = iota		// with codec
+	chdHunkUncompressed
+	chdHunkMini		// offset is an 8-byte value repeated over the whole hunk
+	chdHunkSelf		// offset is the number of another hunk in this CHD with the same data
+	chdHunkParent		// offset is the byte offset of the data in the parent CHD
+)
+
+type chdMapEntry struct {
+	typ		uint8
+	codec	uint8
+	length	uint32
+	offset	uint64
+	crc		uint32
+	hasCRC	bool			// v3/v4 use CRC-32, v5 uses CRC-16
+}
+
+type chdFile struct {
+	f		*os.File
+	hdr		chdHeader
+	hunks	[]chdMapEntry
+	codecs	[4]chdCodec
+	parent	*chdFile		// set by the caller if the CHD needs one
+}
+
+// v1 to v4 have a flag; v5 just leaves the parent SHA-1 zero
+func (h *chdHeader) hasParent() bool {
+	if h.Version < 5 {
+		return h.Flags & 1 != 0
+	}
+	return h.ParentSHA1 != [20]byte{}
+}
+
+func fourcc(s string) uint32 {
+	return binary.BigEndian.Uint32([]byte(s))
+}
+
+func readCHDHeader(r io.ReaderAt) (*chdHeader, error) {
+	raw := make([]byte, 124)
+	n, err := r.ReadAt(raw, 0)
+	if n < 16 {
+		return nil, fmt.Errorf("could not read CHD header: %v", err)
+	}
+	if string(raw[0:8]) != "MComprHD" {
+		return nil, fmt.Errorf("not a CHD file")
+	}
+	be := binary.BigEndian
+	h := &chdHeader{
+		HeaderLen:	be.Uint32(raw[8:]),
+		Version:		be.Uint32(raw[12:]),
+	}
+	need := map[uint32]int{1: 76, 2: 80, 3: 120, 4: 108, 5: 124}[h.Version]
+	if need == 0 {
+		return nil, fmt.Errorf("unsupported CHD version %d", h.Version)
+	}
+	if n < need || int(h.HeaderLen) < need {
+		return nil, fmt.Errorf("CHD header too short")
+	}
+	switch h.Version {
+	case 1, 2:
+		h.Flags = be.Uint32(raw[16:])
+		h.Compressors[0] = be.Uint32(raw[20:])
+		h.TotalHunks = be.Uint32(raw[28:])
+		copy(h.MD5[:], raw[44:])
+		copy(h.ParentMD5[:], raw[60:])
+		seclen := uint32(512)
+		if h.Version == 2 {
+			seclen = be.Uint32(raw[76:])
+		}
+		h.HunkBytes = be.Uint32(raw[24:]) * seclen
+		h.UnitBytes = h.HunkBytes
+		h.LogicalBytes = uint64(h.TotalHunks) * uint64(h.HunkBytes)
+		h.MapOffset = uint64(h.HeaderLen)
+	case 3, 4:
+		h.Flags = be.Uint32(raw[16:])
+		h.Compressors[0] = be.Uint32(raw[20:])
+		h.TotalHunks = be.Uint32(raw[24:])
+		h.LogicalBytes = be.Uint64(raw[28:])
+		h.MetaOffset = be.Uint64(raw[36:])
+		if h.Version == 3 {
+			copy(h.MD5[:], raw[44:])
+			copy(h.ParentMD5[:], raw[60:])
+			h.HunkBytes = be.Uint32(raw[76:])
+			copy(h.SHA1[:], raw[80:])
+			copy(h.ParentSHA1[:], raw[100:])
+			h.RawSHA1 = h.SHA1
+		} else {
+			h.HunkBytes = be.Uint32(raw[44:])
+			copy(h.SHA1[:], raw[48:])
+			copy(h.ParentSHA1[:], raw[68:])
+			copy(h.RawSHA1[:], raw[88:])
+		}
+		h.UnitBytes = h.HunkBytes
+		h.MapOffset = uint64(h.HeaderLen)
+	case 5:
+		for i := range h.Compressors {
+			h.Compressors[i] = be.Uint32(raw[16 + 4 * i:])
+		}
+		h.LogicalBytes = be.Uint64(raw[32:])
+		h.MapOffset = be.Uint64(raw[40:])
+		h.MetaOffset = be.Uint64(raw[48:])
+		h.HunkBytes = be.Uint32(raw[56:])
+		h.UnitBytes = be.Uint32(raw[60:])
+		copy(h.RawSHA1[:], raw[64:])
+		copy(h.SHA1[:], raw[84:])
+		copy(h.ParentSHA1[:], raw[104:])
+		if h.HunkBytes != 0 {
+			h.TotalHunks = uint32((h.LogicalBytes + uint64(h.HunkBytes) - 1) / uint64(h.HunkBytes))
+		}
+	}
+	if h.HunkBytes == 0 || h.UnitBytes == 0 {
+		return nil, fmt.Errorf("CHD has zero hunk or unit size")
+	}
+	return h, nil
+}
+
+func openCHD(filename string) (*chdFile, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	c := &chdFile{
+		f:	f,
+	}
+	hdr, err := readCHDHeader(f)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	c.hdr = *hdr
+	if c.hdr.Version < 3 {
+		f.Close()
+		return nil, chdUnsupportedError(fmt.Sprintf("v%d CHDs only have an MD5 and can't be checked (convert it with chdman copy)", c.hdr.Version))
+	}
+	if c.hdr.Version == 5 {
+		err = c.readMapV5()
+	} else {
+		err = c.readMapV34()
+	}
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("could not read CHD hunk map: %v", err)
+	}
+	return c, nil
+}
+
+func (c *chdFile) Close() error {
+	if c.parent != nil {
+		c.parent.Close()
+	}
+	return c.f.Close()
+}
+
+func (c *chdFile) readMapV34() error {
+	raw := make([]byte, 16 * int(c.hdr.TotalHunks))
+	_, err := c.f.ReadAt(raw, int64(c.hdr.MapOffset))
+	if err != nil {
+		return err
+	}
+	c.hunks = make([]chdMapEntry, c.hdr.TotalHunks)
+	for i := range c.hunks {
+		e := raw[16 * i:]
+		m := &c.hunks[i]
+		m.offset = binary.BigEndian.Uint64(e[0:])
+		m.crc = binary.BigEndian.Uint32(e[8:])
+		m.length = uint32(binary.BigEndian.Uint16(e[12:])) | uint32(e[14]) << 16
+		m.hasCRC = e[15] & 0x10 == 0
+		switch e[15] & 0x0F {
+		case 1:
+			m.typ = chdHunkCompressed
+		case 2:
+			m.typ = chdHunkUncompressed
+		case 3:
+			m.typ = chdHunkMini
+		case 4:
+			m.typ = chdHunkSelf
+			m.hasCRC = false
+		case 5:
+			m.typ = chdHunkParent
+			m.offset *= uint64(c.hdr.HunkBytes)
+			m.hasCRC = false
+		default:
+			return fmt.Errorf("hunk %d has unknown type %d", i, e[15] & 0x0F)
+		}
+	}
+	switch c.hdr.Compressors[0] {
+	case 1, 2:		// zlib and zlib+
+		c.codecs[0] = zlibCodec
+	default:
+		c.codecs[0] = unsupportedCodec(fmt.Sprintf("v%d compression type %d", c.hdr.Version, c.hdr.Compressors[0]))
+	}
+	return nil
+}
+
+// v5 compressed map hunk types
+const (
+	v5Type0 = iota		// codecs 0 through 3
+	v5Type1
+	v5Type2
+	v5Type3
+	v5None
+	v5Self
+	v5Parent
+	v5RLESmall
+	v5RLELarge
+	v5Self0
+	v5Self1
+	v5ParentSelf
+	v5Parent0
+	v5Parent1
+)
+
+func crc16CCITT(data []byte) uint16 {
+	crc := uint16(0xFFFF)
+	for _, c := range data {
+		crc ^= uint16(c) << 8
+		for i := 0; i < 8; i++ {
+			if crc & 0x8000 != 0 {
+				crc = crc << 1 ^ 0x1021
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return crc
+}
+
+func (c *chdFile) readMapV5() error {
+	for i, tag := range c.hdr.Compressors {
+		c.codecs[i] = chdCodecFor(tag)
+	}
+	c.hunks = make([]chdMapEntry, c.hdr.TotalHunks)
+	be := binary.BigEndian
+
+	if c.hdr.Compressors[0] == 0 {		// uncompressed CHDs have a simple map of hunk offsets
+		raw := make([]byte, 4 * len(c.hunks))
+		_, err := c.f.ReadAt(raw, int64(c.hdr.MapOffset))
+		if err != nil {
+			return err
+		}
+		for i := range c.hunks {
+			off := uint64(be.Uint32(raw[4 * i:])) * uint64(c.hdr.HunkBytes)
+			switch {
+			case off != 0:
+				c.hunks[i] = chdMapEntry{
+					typ:		chdHunkUncompressed,
+					offset:	off,
+				}
+			case c.hdr.hasParent():
+				c.hunks[i] = chdMapEntry{
+					typ:		chdHunkParent,
+					offset:	uint64(i) * uint64(c.hdr.HunkBytes),
+				}
+			default:		// all zero
+				c.hunks[i] = chdMapEntry{
+					typ:		chdHunkMini,
+				}
+			}
+		}
+		return nil
+	}
+
+	var mh [16]byte
+	_, err := c.f.ReadAt(mh[:], int64(c.hdr.MapOffset))
+	if err != nil {
+		return err
+	}
+	mapbytes := be.Uint32(mh[0:])
+	curoffset := uint64(be.Uint16(mh[4:])) << 32 | uint64(be.Uint32(mh[6:]))
+	mapcrc := be.Uint16(mh[10:])
+	lengthbits := int(mh[12])
+	selfbits := int(mh[13])
+	parentbits := int(mh[14])
+	raw := make([]byte, mapbytes)
+	_, err = c.f.ReadAt(raw, int64(c.hdr.MapOffset) + 16)
+	if err != nil {
+		return err
+	}
+	b := &bitReader{
+		data:	raw,
+	}
+
+	// first the types, huffman and run-length coded
+	types := make([]uint8, len(c.hunks))
+	h := newHuffmanDecoder(16, 8)
+	err = h.importTreeRLE(b)
+	if err != nil {
+		return err
+	}
+	last := uint8(0)
+	rep := 0
+	for i := range types {
+		if rep > 0 {
+			types[i] = last
+			rep--
+			continue
+		}
+		switch v := uint8(h.decode(b)); v {
+		case v5RLESmall:
+			types[i] = last
+			rep = 2 + h.decode(b)
+		case v5RLELarge:
+			types[i] = last
+			rep = 2 + 16 + h.decode(b) << 4
+			rep += h.decode(b)
+		default:
+			types[i] = v
+			last = v
+		}
+	}
+
+	// then everything else; this builds the map the way MAME stores it in memory so we can check its CRC
+	rawmap := make([]byte, 12 * len(c.hunks))
+	var lastSelf, lastParent uint64
+	hunkUnits := uint64(c.hdr.HunkBytes / c.hdr.UnitBytes)
+	for i := range c.hunks {
+		typ := types[i]
+		offset := curoffset
+		var length uint32
+		var crc uint16
+		switch typ {
+		case v5Type0, v5Type1, v5Type2, v5Type3:
+			length = b.read(lengthbits)
+			curoffset += uint64(length)
+			crc = uint16(b.read(16))
+		case v5None:
+			length = c.hdr.HunkBytes
+			curoffset += uint64(length)
+			crc = uint16(b.read(16))
+		case v5Self:
+			offset = uint64(b.read(selfbits))
+			lastSelf = offset
+		case v5Parent:
+			offset = uint64(b.read(parentbits))
+			lastParent = offset
+		case v5Self1:
+			lastSelf++
+			fallthrough
+		case v5Self0:
+			typ = v5Self
+			offset = lastSelf
+		case v5ParentSelf:
+			typ = v5Parent
+			offset = uint64(i) * uint64(c.hdr.HunkBytes) / uint64(c.hdr.UnitBytes)
+			lastParent = offset
+		case v5Parent1:
+			lastParent += hunkUnits
+			fallthrough
+		case v5Parent0:
+			typ = v5Parent
+			offset = lastParent
+		default:
+			return fmt.Errorf("hunk %d has unknown type %d", i, typ)
+		}
+		e := rawmap[12 * i:]
+		e[0] = typ
+		e[1], e[2], e[3] = byte(length >> 16), byte(length >> 8), byte(length)
+		e[4], e[5] = byte(offset >> 40), byte(offset >> 32)
+		be.PutUint32(e[6:], uint32(offset))
+		be.PutUint16(e[10:], crc)
+
+		m := &c.hunks[i]
+		m.length = length
+		m.offset = offset
+		m.crc = uint32(crc)
+		switch typ {
+		case v5None:
+			m.typ = chdHunkUncompressed
+			m.hasCRC = true
+		case v5Self:
+			m.typ = chdHunkSelf
+		case v5Parent:
+			m.typ = chdHunkParent
+			m.offset *= uint64(c.hdr.UnitBytes)
+		default:
+			m.typ = chdHunkCompressed
+			m.codec = typ
+			m.hasCRC = true
+		}
+	}
+	if b.overflow() {
+		return fmt.Errorf("compressed map truncated")
+	}
+	if crc16CCITT(rawmap) != mapcrc {
+		return fmt.Errorf("compressed map has bad CRC")
+	}
+	return nil
+}
+
+// reasons a hunk can't be read, as opposed to it being damaged
+type chdUnsupportedError string
+
+func (e chdUnsupportedError) Error() string {
+	return string(e)
+}
+
+// read hunk n into buf, which must be HunkBytes long
+func (c *chdFile) readHunk(n uint32, buf []byte) error {
+	return c.readHunkDepth(n, buf, 0)
+}
+
+func (c *chdFile) readHunkDepth(n uint32, buf []byte, depth int) error {
+	if n >= uint32(len(c.hunks)) {
+		return fmt.Errorf("hunk %d out of range", n)
+	}
+	if depth > len(c.hunks) {		// a self reference loop would be a sure sign of a broken map
+		return fmt.Errorf("hunk %d refers to itself", n)
+	}
+	m := &c.hunks[n]
+	switch m.typ {
+	case chdHunkCompressed:
+		src := make([]byte, m.length)
+		_, err := c.f.ReadAt(src, int64(m.offset))
+		if err != nil {
+			return fmt.Errorf("could not read hunk %d: %v", n, err)
+		}
+		err = c.codecs[m.codec](src, buf)
+		if err != nil {
+			if _, ok := err.(chdUnsupportedError); ok {
+				return err
+			}
+			return fmt.Errorf("could not decompress hunk %d: %v", n, err)
+		}
+	case chdHunkUncompressed:
+		_, err := c.f.ReadAt(buf, int64(m.offset))
+		if err != nil {
+			return fmt.Errorf("could not read hunk %d: %v", n, err)
+		}
+	case chdHunkMini:
+		var v [8]byte
+		binary.BigEndian.PutUint64(v[:], m.offset)
+		for i := range buf {
+			buf[i] = v[i % 8]
+		}
+	case chdHunkSelf:
+		return c.readHunkDepth(uint32(m.offset), buf, depth + 1)
+	case chdHunkParent:
+		if c.parent == nil {
+			return chdUnsupportedError(fmt.Sprintf("hunk %d needs parent CHD %s, which wasn't found", n, hex.EncodeToString(c.hdr.ParentSHA1[:])))
+		}
+		return c.parent.readAt(buf, m.offset)
+	}
+	if m.hasCRC {
+		ok := true
+		if c.hdr.Version == 5 {
+			ok = uint32(crc16CCITT(buf)) == m.crc
+		} else {
+			ok = crc32.ChecksumIEEE(buf) == m.crc
+		}
+		if !ok {
+			return fmt.Errorf("hunk %d has bad CRC", n)
+		}
+	}
+	return nil
+}
+
+// read the logical data at off, which need not be hunk-aligned
+func (c *chdFile) readAt(p []byte, off uint64) error {
+	hb := uint64(c.hdr.HunkBytes)
+	buf := make([]byte, hb)
+	for len(p) > 0 {
+		n := uint32(off / hb)
+		err := c.readHunk(n, buf)
+		if err != nil {
+			return err
+		}
+		k := copy(p, buf[off % hb:])
+		p = p[k:]
+		off += uint64(k)
+	}
+	return nil
+}
+
+type chdMetadata struct {
+	Tag		uint32
+	Flags	uint8
+	Data		[]byte
+}
+
+const chdMetaChecksum = 0x01		// included in the overall SHA-1
+
+func (c *chdFile) metadata() ([]chdMetadata, error) {
+	var md []chdMetadata
+	seen := map[uint64]bool{}
+	for off := c.hdr.MetaOffset; off != 0; {
+		if seen[off] {
+			return nil, fmt.Errorf("metadata list loops")
+		}
+		seen[off] = true
+		var raw [16]byte
+		_, err := c.f.ReadAt(raw[:], int64(off))
+		if err != nil {
+			return nil, fmt.Errorf("could not read metadata: %v", err)
+		}
+		fl := binary.BigEndian.Uint32(raw[4:])
+		m := chdMetadata{
+			Tag:		binary.BigEndian.Uint32(raw[0:]),
+			Flags:	uint8(fl >> 24),
+			Data:	make([]byte, fl & 0xFFFFFF),
+		}
+		_, err = c.f.ReadAt(m.Data, int64(off) + 16)
+		if err != nil {
+			return nil, fmt.Errorf("could not read metadata: %v", err)
+		}
+		md = append(md, m)
+		off = binary.BigEndian.Uint64(raw[8:])
+	}
+	return md, nil
+}
+
+// the SHA-1 MAME uses to identify the CHD: the data's SHA-1 followed by the tag and SHA-1 of each checksummed metadata item, sorted
+// v3 predates this and just uses the data's SHA-1
+func (c *chdFile) overallSHA1(raw [20]byte) ([20]byte, error) {
+	if c.hdr.Version < 4 {
+		return raw, nil
+	}
+	md, err := c.metadata()
+	if err != nil {
+		return [20]byte{}, err
+	}
+	var items [][]byte
+	for _, m := range md {
+		if m.Flags & chdMetaChecksum == 0 {
+			continue
+		}
+		item := make([]byte, 4, 24)
+		binary.BigEndian.PutUint32(item, m.Tag)
+		sum := sha1.Sum(m.Data)
+		items = append(items, append(item, sum[:]...))
+	}
+	sort.Slice(items, func(i, j int) bool {
+		return bytes.Compare(items[i], items[j]) < 0
+	})
+	h := sha1.New()
+	h.Write(raw[:])
+	for _, item := range items {
+		h.Write(item)
+	}
+	var sum [20]byte
+	copy(sum[:], h.Sum(nil))
+	return sum, nil
+}
+
+// the result of decoding a whole CHD
+type chdVerify struct {
+	Header		chdHeader
+	RawSHA1		[20]byte
+	SHA1			[20]byte
+	BadHunks		[]uint32
+	FirstError		error
+}
+
+func (v *chdVerify) good() bool {
+	return len(v.BadHunks) == 0 && v.RawSHA1 == v.Header.RawSHA1 && v.SHA1 == v.Header.SHA1
+}
+
+func (v *chdVerify) String() string {
+	if v.good() {
+		return "ok"
+	}
+	var s []string
+	if len(v.BadHunks) != 0 {
+		s = append(s, fmt.Sprintf("%d of %d hunks damaged (first: %v)", len(v.BadHunks), v.Header.TotalHunks, v.FirstError))
+	}
+	if v.RawSHA1 != v.Header.RawSHA1 {
+		s = append(s, fmt.Sprintf("data SHA-1 is %x, header says %x", v.RawSHA1, v.Header.RawSHA1))
+	}
+	if v.SHA1 != v.Header.SHA1 {
+		s = append(s, fmt.Sprintf("SHA-1 is %x, header says %x", v.SHA1, v.Header.SHA1))
+	}
+	return strings.Join(s, "; ")
+}
+
+// decode every hunk and hash the result
+// damaged hunks are recorded and hashed as zeroes; the error return is only for when the CHD can't be checked at all
+func (c *chdFile) verify() (*chdVerify, error) {
+	v := &chdVerify{
+		Header:	c.hdr,
+	}
+	h := sha1.New()
+	buf := make([]byte, c.hdr.HunkBytes)
+	remaining := c.hdr.LogicalBytes
+	for n := uint32(0); n < c.hdr.TotalHunks && remaining > 0; n++ {
+		err := c.readHunk(n, buf)
+		if _, ok := err.(chdUnsupportedError); ok {
+			return nil, err
+		} else if err != nil {
+			if v.FirstError == nil {
+				v.FirstError = err
+			}
+			v.BadHunks = append(v.BadHunks, n)
+			for i := range buf {
+				buf[i] = 0
+			}
+		}
+		k := uint64(len(buf))
+		if k > remaining {
+			k = remaining
+		}
+		h.Write(buf[:k])
+		remaining -= k
+	}
+	copy(v.RawSHA1[:], h.Sum(nil))
+	sum, err := c.overallSHA1(v.RawSHA1)
+	if err != nil {
+		return nil, err
+	}
+	v.SHA1 = sum
+	return v, nil
+}
@@ -0,0 +1,145 @@
+// 18 october 2026
+package main
+
+import (
+	"testing"
+	"os"
+	"bytes"
+	"encoding/hex"
+	"path/filepath"
+)
+
+// made by testdata/mkchd.py, which also prints the SHA-1s; those are of the data that went in, so they don't depend on our decoders being right
+var testCHDs = []struct {
+	file		string
+	version	uint32
+	sha1		string
+}{
+	{"cdaudio.chd",	5,	"60a837377342d9a1582d3c5b96bbfe9f3c5e5cf8"},		// cdfl
+	{"cdmode1.chd",	5,	"2f6ed943c3f8631be3e09fecadacf1826ec4eb09"},		// cdzl and cdlz, ECC made again
+	{"cdmode2.chd",	5,	"6df309e7c25f8550a086280241b3a4f50c6fb9ee"},		// the same with mode 2 form 1 sectors
+	{"flac.chd",		5,	"9702697e1c2d812f715a2a9ac61df291b49d59ce"},
+	{"huff.chd",		5,	"8feb1e5cd554d4e1125ad8f1f0d80cb1a4113545"},
+	{"lzma.chd",		5,	"434f519766cfbdc483b45e7fa1114d880851474f"},
+	{"v3.chd",		3,	"fbf7353e2da8a8fb61ae1c671243e43c9c8c81bf"},
+	{"v4.chd",		4,	"ff428334bc39991daba191c1757667c93625e51e"},
+	{"v5raw.chd",		5,	"46e10e195634aee6fdc5a9ba9b6405273e78a323"},		// uncompressed, with the simple map
+	{"zlib.chd",		5,	"2e2ca91869ef5fd430a07f83ddab45bd2b3d0eae"},		// with uncompressed and self hunks too
+}
+
+func TestCHDDecode(t *testing.T) {
+	for _, tc := range testCHDs {
+		c, err := openCHD(filepath.Join("testdata", tc.file))
+		if err != nil {
+			t.Errorf("%s: could not open: %v", tc.file, err)
+			continue
+		}
+		if c.hdr.Version != tc.version {
+			t.Errorf("%s: version %d, want %d", tc.file, c.hdr.Version, tc.version)
+		}
+		v, err := c.verify()
+		c.Close()
+		if err != nil {
+			t.Errorf("%s: could not verify: %v", tc.file, err)
+			continue
+		}
+		if got := hex.EncodeToString(v.RawSHA1[:]); got != tc.sha1 {
+			t.Errorf("%s: data SHA-1 is %s, want %s", tc.file, got, tc.sha1)
+		}
+		if !v.good() {
+			t.Errorf("%s: %v", tc.file, v)
+		}
+	}
+}
+
+// every stripped sector of both CDs must come back exactly, not just hash the same as a whole
+func TestCDECC(t *testing.T) {
+	for _, file := range []string{"cdmode1.chd", "cdmode2.chd"} {
+		c, err := openCHD(filepath.Join("testdata", file))
+		if err != nil {
+			t.Fatalf("%s: could not open: %v", file, err)
+		}
+		buf := make([]byte, c.hdr.HunkBytes)
+		for n := uint32(0); n < c.hdr.TotalHunks; n++ {
+			err := c.readHunk(n, buf)
+			if err != nil {
+				t.Fatalf("%s: hunk %d: %v", file, n, err)
+			}
+			for f := 0; f < len(buf); f += cdFrameSize {
+				sector := append([]byte(nil), buf[f:f + cdSectorData]...)
+				want := append([]byte(nil), sector...)
+				for i := eccPOffset; i < eccQOffset + 2 * eccQNumBytes; i++ {
+					sector[i] = 0
+				}
+				eccGenerate(sector)
+				if !bytes.Equal(sector, want) {
+					t.Errorf("%s: hunk %d frame %d: ECC doesn't match", file, n, f / cdFrameSize)
+				}
+			}
+		}
+		c.Close()
+	}
+}
+
+// testdata/v3.chd has the MD5 of its data in the header; v4 dropped it
+func TestCHDMD5(t *testing.T) {
+	for _, tc := range []struct {
+		file		string
+		md5		string
+		want	auditState
+	}{
+		{"v3.chd",	"737e9523e3cc0501adffde140f51fa10",	stateOK},
+		{"v3.chd",	"00000000000000000000000000000000",	stateWrongMD5},
+		{"v4.chd",	"737e9523e3cc0501adffde140f51fa10",	stateUnverifiable},
+	} {
+		f, err := os.Open(filepath.Join("testdata", tc.file))
+		if err != nil {
+			t.Fatal(err)
+		}
+		state, err := md5check_chd(f, tc.md5)
+		f.Close()
+		if err != nil {
+			t.Fatalf("%s: %v", tc.file, err)
+		}
+		if state != tc.want {
+			t.Errorf("%s with MD5 %s is %v, want %v", tc.file, tc.md5, state, tc.want)
+		}
+	}
+}
+
+// made by chdman itself, from inputs testdata/mkchdman.py makes, so they check our reading of the format against MAME's and not just against mkchd.py's
+// sha1 is of the input, which is what the raw view has to give; verify() checks the data against the SHA-1 chdman put in the header
+var chdmanCHDs = []struct {
+	file		string
+	sha1		string
+}{
+	{"chdman-cd.chd",	"ad8018fd3e93a113d9bebcb99d75b76357e530f2"},		// cdlz and cdfl, mode 1 then audio
+	{"chdman-hd.chd",	"74647fc07faa426dd0074301078ad1f64b6e8d79"},		// lzma and huff
+}
+
+func TestCHDMan(t *testing.T) {
+	for _, tc := range chdmanCHDs {
+		t.Run(tc.file, func(t *testing.T) {
+			if _, err := os.Stat(filepath.Join("testdata", tc.file)); os.IsNotExist(err) {
+				t.Skipf("%s not made yet; run testdata/mkchdman.py where chdman is installed", tc.file)
+			}
+			c, err := openCHD(filepath.Join("testdata", tc.file))
+			if err != nil {
+				t.Fatalf("could not open: %v", err)
+			}
+			v, err := c.verify()
+			c.Close()
+			if err != nil {
+				t.Fatalf("could not verify: %v", err)
+			}
+			if !v.good() {
+				t.Errorf("doesn't match what chdman says: %v", v)
+			}
+			img, sum := rawImageSHA1(t, tc.file)
+			img.Close()
+			if sum != tc.sha1 {
+				t.Errorf("raw view SHA-1 is %s, want %s", sum, tc.sha1)
+			}
+		})
+	}
+}
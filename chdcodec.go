@@ -0,0 +1,165 @@
+// 18 october 2026
+package main
+
+import (
+	"fmt"
+	"io"
+	"bytes"
+	"compress/flate"
+)
+
+// a codec decompresses one hunk; dst is always exactly the size of the hunk
+type chdCodec func(src []byte, dst []byte) error
+
+func chdCodecFor(tag uint32) chdCodec {
+	switch tag {
+	case 0:
+		return unsupportedCodec("no codec")		// shouldn't be referenced by the map
+	case fourcc("zlib"):
+		return zlibCodec
+	case fourcc("lzma"):
+		return lzmaDecompress
+	case fourcc("huff"):
+		return huffCodec
+	case fourcc("flac"):
+		return flacCodec
+	case fourcc("cdzl"):
+		return cdCodec(zlibCodec, zlibCodec)
+	case fourcc("cdlz"):
+		return cdCodec(lzmaDecompress, zlibCodec)
+	case fourcc("cdfl"):
+		return cdflCodec
+	}
+	return unsupportedCodec(fmt.Sprintf("codec %q", tagString(tag)))
+}
+
+// the opposite of fourcc()
+func tagString(tag uint32) string {
+	var name [4]byte
+	for i := range name {
+		name[i] = byte(tag >> uint(24 - 8 * i))
+	}
+	return string(name[:])
+}
+
+func unsupportedCodec(what string) chdCodec {
+	return func(src []byte, dst []byte) error {
+		return chdUnsupportedError(what + " not supported")
+	}
+}
+
+// raw deflate, no zlib header
+func zlibCodec(src []byte, dst []byte) error {
+	r := flate.NewReader(bytes.NewReader(src))
+	defer r.Close()
+	_, err := io.ReadFull(r, dst)
+	return err
+}
+
+func huffCodec(src []byte, dst []byte) error {
+	b := &bitReader{
+		data:	src,
+	}
+	h := newHuffmanDecoder(256, 16)
+	err := h.importTreeHuffman(b)
+	if err != nil {
+		return err
+	}
+	for i := range dst {
+		dst[i] = byte(h.decode(b))
+	}
+	if b.overflow() {
+		return fmt.Errorf("huffman data truncated")
+	}
+	return nil
+}
+
+// the first byte says which byte order the samples were in
+func flacCodec(src []byte, dst []byte) error {
+	if len(src) == 0 {
+		return fmt.Errorf("empty FLAC hunk")
+	}
+	var big bool
+	switch src[0] {
+	case 'L':
+		big = false
+	case 'B':
+		big = true
+	default:
+		return fmt.Errorf("bad FLAC hunk byte order %q", src[0])
+	}
+	_, err := flacDecode(src[1:], dst, big)
+	return err
+}
+
+// CD hunks are whole frames of sector data followed by subcode data; the codecs compress all the sector data then all the subcode data separately
+const (
+	cdFrameSize	= 2448
+	cdSectorData	= 2352
+	cdSubcodeData	= 96
+)
+
+// split up the decompressed sector and subcode data into frames again
+func cdReassemble(buf []byte, dst []byte, frames int) {
+	for i := 0; i < frames; i++ {
+		copy(dst[i * cdFrameSize:], buf[i * cdSectorData:(i + 1) * cdSectorData])
+		copy(dst[i * cdFrameSize + cdSectorData:], buf[frames * cdSectorData + i * cdSubcodeData:frames * cdSectorData + (i + 1) * cdSubcodeData])
+	}
+}
+
+// the mode 1 sectors whose sync header and ECC could be regenerated had them removed; a bitmap at the start says which
+func cdCodec(base chdCodec, subcode chdCodec) chdCodec {
+	return func(src []byte, dst []byte) error {
+		frames := len(dst) / cdFrameSize
+		complenBytes := 2
+		if len(dst) >= 65536 {
+			complenBytes = 3
+		}
+		eccBytes := (frames + 7) / 8
+		headerBytes := eccBytes + complenBytes
+		if len(src) < headerBytes {
+			return fmt.Errorf("CD hunk too short")
+		}
+		complen := int(src[eccBytes]) << 8 | int(src[eccBytes + 1])
+		if complenBytes > 2 {
+			complen = complen << 8 | int(src[eccBytes + 2])
+		}
+		if headerBytes + complen > len(src) {
+			return fmt.Errorf("CD hunk sector data overruns hunk")
+		}
+		buf := make([]byte, frames * cdFrameSize)
+		err := base(src[headerBytes:headerBytes + complen], buf[:frames * cdSectorData])
+		if err != nil {
+			return fmt.Errorf("sector data: %v", err)
+		}
+		err = subcode(src[headerBytes + complen:], buf[frames * cdSectorData:])
+		if err != nil {
+			return fmt.Errorf("subcode data: %v", err)
+		}
+		cdReassemble(buf, dst, frames)
+		for i := 0; i < frames; i++ {
+			if src[i / 8] & (1 << uint(i % 8)) != 0 {
+				sector := dst[i * cdFrameSize:]
+				copy(sector, cdSyncHeader[:])
+				eccGenerate(sector)
+			}
+		}
+		return nil
+	}
+}
+
+// sector data is FLAC (big-endian, like CD audio in a CHD) with the subcode data deflated right after it
+func cdflCodec(src []byte, dst []byte) error {
+	frames := len(dst) / cdFrameSize
+	buf := make([]byte, frames * cdFrameSize)
+	n, err := flacDecode(src, buf[:frames * cdSectorData], true)
+	if err != nil {
+		return fmt.Errorf("sector data: %v", err)
+	}
+	err = zlibCodec(src[n:], buf[frames * cdSectorData:])
+	if err != nil {
+		return fmt.Errorf("subcode data: %v", err)
+	}
+	cdReassemble(buf, dst, frames)
+	return nil
+}
@@ -0,0 +1,146 @@
+// 18 october 2026
+package main
+
+import (
+	"fmt"
+	"os"
+	"bytes"
+	"strings"
+	"unicode"
+)
+
+// a human-readable summary of a CHD's header and metadata, like chdman info gives
+// shown as <disk>.chd.info next to each CHD and printed by the chdinfo command
+
+var oldCompression = map[uint32]string{
+	0:	"none",
+	1:	"zlib",
+	2:	"zlib+",
+	3:	"A/V",
+}
+
+func (h *chdHeader) compression() string {
+	if h.Version < 5 {
+		if s, ok := oldCompression[h.Compressors[0]]; ok {
+			return s
+		}
+		return fmt.Sprintf("unknown (%d)", h.Compressors[0])
+	}
+	var s []string
+	for _, c := range h.Compressors {
+		if c != 0 {
+			s = append(s, strings.TrimSpace(tagString(c)))
+		}
+	}
+	if len(s) == 0 {
+		return "none"
+	}
+	return strings.Join(s, ", ")
+}
+
+// metadata is almost always text, but not always
+func printableMetadata(data []byte) (string, bool) {
+	s := strings.TrimRight(string(data), "\x00")
+	for _, r := range s {
+		if !unicode.IsPrint(r) && r != '\n' {
+			return "", false
+		}
+	}
+	return s, true
+}
+
+func chdInfo(filename string) (string, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	hdr, err := readCHDHeader(f)
+	if err != nil {
+		return "", err
+	}
+
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "File:          %s\n", filename)
+	fmt.Fprintf(&b, "CHD version:   %d\n", hdr.Version)
+	fmt.Fprintf(&b, "Logical size:  %d bytes\n", hdr.LogicalBytes)
+	fmt.Fprintf(&b, "Hunk size:     %d bytes\n", hdr.HunkBytes)
+	fmt.Fprintf(&b, "Total hunks:   %d\n", hdr.TotalHunks)
+	if hdr.Version >= 5 {
+		fmt.Fprintf(&b, "Unit size:     %d bytes\n", hdr.UnitBytes)
+	}
+	fmt.Fprintf(&b, "Compression:   %s\n", hdr.compression())
+	if hdr.Version < 3 {
+		fmt.Fprintf(&b, "MD5:           %x\n", hdr.MD5)
+		if hdr.hasParent() {
+			fmt.Fprintf(&b, "Parent MD5:    %x\n", hdr.ParentMD5)
+		}
+		return b.String(), nil		// no metadata, or at least none we know how to find
+	}
+	fmt.Fprintf(&b, "SHA-1:         %x\n", hdr.SHA1)
+	if hdr.Version >= 4 {
+		fmt.Fprintf(&b, "Data SHA-1:    %x\n", hdr.RawSHA1)
+	}
+	if hdr.hasParent() {
+		fmt.Fprintf(&b, "Parent SHA-1:  %x\n", hdr.ParentSHA1)
+	}
+
+	// metadata doesn't need the hunk map
+	c := &chdFile{
+		f:		f,
+		hdr:		*hdr,
+	}
+	md, err := c.metadata()
+	if err != nil {
+		return "", err
+	}
+	if len(md) != 0 {
+		fmt.Fprintf(&b, "Metadata:\n")
+	}
+	for _, m := range md {
+		checksum := ""
+		if m.Flags & chdMetaChecksum != 0 {
+			checksum = " (checksummed)"
+		}
+		if s, ok := printableMetadata(m.Data); ok {
+			fmt.Fprintf(&b, "  %s%s: %s\n", tagString(m.Tag), checksum, s)
+		} else {
+			fmt.Fprintf(&b, "  %s%s: %d bytes of binary data\n", tagString(m.Tag), checksum, len(m.Data))
+		}
+	}
+	for _, m := range md {
+		switch m.Tag {
+		case fourcc("GDDD"):
+			// this one is commas, not spaces
+			f := parseMetadataFields(bytes.Replace(m.Data, []byte(","), []byte(" "), -1))
+			fmt.Fprintf(&b, "Hard disk:     %s cylinders, %s heads, %s sectors, %s bytes per sector\n", f["CYLS"], f["HEADS"], f["SECS"], f["BPS"])
+		case fourcc("CHTR"), fourcc("CHT2"), fourcc("CHGT"), fourcc("CHGD"):
+			writeTrackInfo(&b, parseMetadataFields(m.Data))
+		case fourcc("CHCD"):
+			tracks, err := parseCHCD(m.Data)
+			if err != nil {
+				fmt.Fprintf(&b, "Tracks:        %v\n", err)
+			}
+			for _, f := range tracks {
+				writeTrackInfo(&b, f)
+			}
+		case fourcc("DVD "):
+			fmt.Fprintf(&b, "DVD:           %d sectors\n", hdr.LogicalBytes / 2048)
+		}
+	}
+	return b.String(), nil
+}
+
+func writeTrackInfo(b *bytes.Buffer, f map[string]string) {
+	fmt.Fprintf(b, "Track %s:%*s%s, %s frames", f["TRACK"], 8 - len(f["TRACK"]), "", f["TYPE"], f["FRAMES"])
+	if f["SUBTYPE"] != "" && f["SUBTYPE"] != "NONE" {
+		fmt.Fprintf(b, ", subcode %s", f["SUBTYPE"])
+	}
+	if f["PREGAP"] != "" && f["PREGAP"] != "0" {
+		fmt.Fprintf(b, ", pregap %s", f["PREGAP"])
+	}
+	if f["POSTGAP"] != "" && f["POSTGAP"] != "0" {
+		fmt.Fprintf(b, ", postgap %s", f["POSTGAP"])
+	}
+	fmt.Fprintf(b, "\n")
+}
@@ -4,10 +4,8 @@ package main
 import (
 	"fmt"
 	"os"
-	"io"
 	"path/filepath"
 	"encoding/hex"
-	"encoding/binary"
 	"bytes"
 	"strings"
 	"log"
@@ -18,68 +16,303 @@ import (
 
 type CHDs map[string]*CHD
 
-var sha1Off = map[uint32]int64{
-	// right now using standard; comment has parent (raw if also available)
-	3:	80,		// 100
-	4:	48,		// 68 (raw 88)
-	5:	84,		// 104 (raw 64)
-}
-
-const versionFieldOff = 12
-
-func sha1check_chd(f *os.File, expectstring string) (bool, error) {
+// compares the SHA-1 in the header; CHDs older than v3 give stateUnverifiable
+func sha1check_chd(f *os.File, expectstring string) (auditState, error) {
 	expected, err := hex.DecodeString(expectstring)
 	if err != nil {
 		log.Fatalf("hex decode error reading sha1 (%q): %v", expectstring, err)
 	}
+	compare := func(got []byte) auditState {
+		if bytes.Equal(expected, got) {
+			return stateOK
+		}
+		return stateWrongSHA1
+	}
+
+	fi, err := f.Stat()
+	if err != nil {
+		return stateMissing, fmt.Errorf("stat of CHD failed: %v", err)
+	}
+	if sum := cacheLookup(f.Name(), fi, ""); sum != nil {
+		got, _ := hex.DecodeString(sum.SHA1)
+		return compare(got), nil
+	}
+
+	hdr, err := readCHDHeader(f)
+	if err != nil {
+		return stateMissing, err
+	}
+	if hdr.Version < 3 {
+		return stateUnverifiable, nil
+	}
 
-	var version uint32
-	var sha1 [20]byte
+	cacheStore(f.Name(), fi, "", &cacheSum{
+		SHA1:	hex.EncodeToString(hdr.SHA1[:]),
+	})
+	return compare(hdr.SHA1[:]), nil
+}
 
-	_, err = f.Seek(versionFieldOff, 0)
+// for XML files old enough to only give an MD5; only CHDs up to v3 have one in the header
+// with no hash at all there's nothing to check, just like ROMs without a CRC-32
+func md5check_chd(f *os.File, expectstring string) (auditState, error) {
+	if expectstring == "" {
+		return stateOK, nil
+	}
+	expected, err := hex.DecodeString(expectstring)
 	if err != nil {
-		return false, fmt.Errorf("seek in CHD to find version number failed: %v", err)
+		log.Fatalf("hex decode error reading md5 (%q): %v", expectstring, err)
 	}
-	err = binary.Read(f, binary.BigEndian, &version)
+	hdr, err := readCHDHeader(f)
 	if err != nil {
-		return false, fmt.Errorf("read version number from CHD failed: %v", err)
+		return stateMissing, err
 	}
+	if hdr.Version > 3 {
+		return stateUnverifiable, nil
+	}
+	if !bytes.Equal(expected, hdr.MD5[:]) {
+		return stateWrongMD5, nil
+	}
+	return stateOK, nil
+}
+
+// how much of a CHD we check when mounting
+const (
+	headerCHDCheck = "header"		// trust the SHA-1 in the header
+	deepCHDCheck = "deep"			// decode every hunk and hash the result; slow, but catches damage
+)
+
+var chdCheck = headerCHDCheck
 
-	if sha1Off[version] == 0 {
-		return false, fmt.Errorf("invalid CHD version %d", version)
+// the verification cache entry for the result of a deep check; its SHA-1 is empty if the CHD was damaged
+const deepCacheEntry = "deep"
+
+func cacheDeepResult(fn string, v *chdVerify) {
+	fi, err := os.Stat(fn)
+	if err != nil {
+		return
+	}
+	sum := &cacheSum{}
+	if v.good() {
+		sum.SHA1 = hex.EncodeToString(v.SHA1[:])
+	}
+	cacheStore(fn, fi, deepCacheEntry, sum)
+}
+
+// the header of fn has already been found to match, so all that's left is to see if the contents do too
+func (g *Game) deepcheck_chd(fn string) (bool, error) {
+	fi, err := os.Stat(fn)
+	if err != nil {
+		return false, err
+	}
+	if sum := cacheLookup(fn, fi, deepCacheEntry); sum != nil {
+		return sum.SHA1 != "", nil
+	}
+	v, err := g.scrubCHD(fn)
+	if _, ok := err.(chdUnsupportedError); ok {
+		log.Printf("could not fully check CHD %s (%v); going by its header\n", fn, err)
+		return true, nil
+	} else if err != nil {		// including a broken map
+		log.Printf("CHD %s is damaged: %v\n", fn, err)
+		return false, nil
+	}
+	cacheDeepResult(fn, v)
+	if !v.good() {
+		log.Printf("CHD %s is damaged: %v\n", fn, v)
 	}
-	_, err = f.Seek(sha1Off[version], 0)
+	return v.good(), nil
+}
+
+// decode the whole of the given CHD
+func (g *Game) scrubCHD(fn string) (*chdVerify, error) {
+	c, err := g.openCHDChain(fn)
 	if err != nil {
-		return false, fmt.Errorf("seek in CHD to get SHA-1 sum failed: %v", err)
+		return nil, err
 	}
-	_, err = io.ReadFull(f, sha1[:])
+	defer c.Close()
+	return c.verify()
+}
+
+// open a CHD along with the parents it needs, looking for them among the CHDs of g and its parents
+// a missing parent is only an error once a hunk is actually needed from it
+func (g *Game) openCHDChain(fn string) (*chdFile, error) {
+	c, err := openCHD(fn)
 	if err != nil {
-		return false, fmt.Errorf("read of SHA-1 failed: %v", err)
+		return nil, err
+	}
+	seen := map[string]bool{
+		fn:	true,
+	}
+	for p := c; p.hdr.hasParent(); p = p.parent {
+		pfn := g.findParentCHD(p.hdr.ParentSHA1)
+		if pfn == "" || seen[pfn] {
+			break
+		}
+		seen[pfn] = true
+		p.parent, err = openCHD(pfn)
+		if err != nil {
+			c.Close()
+			return nil, fmt.Errorf("could not open parent CHD %s: %v", pfn, err)
+		}
+	}
+	return c, nil
+}
+
+// parents are usually the same disk of a parent game, so look there before going to the CHD index
+func (g *Game) findParentCHD(sha1 [20]byte) string {
+	for _, a := range g.ancestors() {
+		for _, chd := range a.CHDs {
+			for _, d := range dirs {
+				fn := filename_CHD(d, filepath.Join(a.diskDir(), a.Name), chd.Name)
+				f, err := os.Open(fn)
+				if err != nil {
+					continue
+				}
+				hdr, err := readCHDHeader(f)
+				f.Close()
+				if err == nil && hdr.SHA1 == sha1 {
+					return fn
+				}
+			}
+		}
+	}
+	if l := lookupCHD(sha1); len(l) != 0 {
+		return l[0]
+	}
+	return ""
+}
+
+// follow the parent SHA-1s in the headers of fn, its parent, and so on; returns what's wrong with the chain if any of them can't be found
+func (g *Game) chdParentChain(fn string) (string, error) {
+	seen := map[string]bool{}
+	for !seen[fn] {
+		seen[fn] = true
+		f, err := os.Open(fn)
+		if err != nil {
+			return "", err
+		}
+		hdr, err := readCHDHeader(f)
+		f.Close()
+		if err != nil {
+			return "", fmt.Errorf("%s: %v", fn, err)
+		}
+		if !hdr.hasParent() {
+			return "", nil
+		}
+		if hdr.Version < 3 {
+			return fmt.Sprintf("%s is a v%d CHD with a parent, which can only be found by MD5; it can't be checked", fn, hdr.Version), nil
+		}
+		pfn := g.findParentCHD(hdr.ParentSHA1)
+		if pfn == "" {
+			return fmt.Sprintf("parent CHD %x of %s not found", hdr.ParentSHA1, fn), nil
+		}
+		fn = pfn
+	}
+	return fmt.Sprintf("CHD %s is its own parent", fn), nil
+}
+
+// look the CHD up in the CHD index, so it's found whatever it's called and wherever it is
+func (g *Game) checkCHDIndex(chd *CHD) (bool, string, error) {
+	var sum [20]byte
+	if _, err := hex.Decode(sum[:], []byte(chd.SHA1)); err != nil {
+		log.Fatalf("hex decode error reading sha1 (%q): %v", chd.SHA1, err)
+	}
+	for _, fn := range lookupCHD(sum) {
+		good, err := g.checkCHDFile(fn, chd)
+		if err != nil {
+			return false, "", err
+		}
+		if good {
+			return true, fn, nil
+		}
 	}
+	return false, "", nil
+}
 
-	return bytes.Equal(expected, sha1[:]), nil
+// every file that could be the given CHD, in the order findCHDs() tries them
+func (g *Game) chdFiles(chd *CHD) []string {
+	var files []string
+	for _, d := range dirs {
+		for _, dir := range append([]string{g.Name}, g.Parents...) {
+			fn := filename_CHD(d, g.diskPath(dir), chd.Name)
+			if _, err := os.Stat(fn); err == nil {
+				files = append(files, fn)
+			}
+		}
+	}
+	return files
 }
 
 func filename_CHD(rompath string, gamename string, CHDname string) string {
-	return filepath.Join(rompath, gamename, CHDname + ".chd")
+	return normalizedPath(rompath, filepath.Join(gamename, CHDname + ".chd"))
 }
 
-func (g *Game) checkCHDIn(rompath string, chd *CHD) (bool, string, error) {
-	try := func(dir string) (bool, string, error) {
-		fn := filename_CHD(rompath, dir, chd.Name)
-		file, err := os.Open(fn)
-		if os.IsNotExist(err) {
-			return false, "", nil
-		} else if err != nil {
-			return false, "", fmt.Errorf("could not open CHD file %s: %v", fn, err)
-		}
-		good, err := sha1check_chd(file, chd.SHA1)
-		file.Close()
+// check the CHD at fn, noting the result in chd's audit
+func (g *Game) checkCHDFile(fn string, chd *CHD) (bool, error) {
+	file, err := os.Open(fn)
+	if os.IsNotExist(err) {
+		return false, nil
+	} else if err != nil {
+		return false, fmt.Errorf("could not open CHD file %s: %v", fn, err)
+	}
+	var state auditState
+	if chd.SHA1 == "" {
+		state, err = md5check_chd(file, chd.MD5)
+	} else {
+		state, err = sha1check_chd(file, chd.SHA1)
+	}
+	file.Close()
+	if err != nil {
+		return false, fmt.Errorf("could not check CHD %s: %v", fn, err)
+	}
+	if state == stateUnverifiable {
+		chd.Audit.note(state, fn)
+		if chd.SHA1 == "" {
+			g.problem("disk %s: %s is too new a CHD to have the MD5 the XML file gives", chd.Name, fn)
+		} else {
+			g.problem("disk %s: %s is too old a CHD to have a SHA-1 (convert it with chdman copy)", chd.Name, fn)
+		}
+		return false, nil
+	}
+	if state != stateOK {
+		chd.Audit.note(state, fn)
+		return false, nil
+	}
+	broken, err := g.chdParentChain(fn)
+	if err != nil {
+		return false, fmt.Errorf("could not follow parents of CHD %s: %v", fn, err)
+	}
+	if broken != "" {
+		chd.Audit.note(stateNoParent, fn)
+		g.problem("disk %s: %s", chd.Name, broken)
+		return false, nil
+	}
+	if chdCheck == deepCHDCheck {
+		good, err := g.deepcheck_chd(fn)
 		if err != nil {
-			return false, "", fmt.Errorf("could not calculate SHA-1 sum of CHD %s: %v", fn, err)
+			return false, fmt.Errorf("could not check contents of CHD %s: %v", fn, err)
 		}
 		if !good {
-			return false, "", nil
+			chd.Audit.note(stateWrongSHA1, fn)
+			return false, nil
+		}
+	}
+	if chd.Status == baddump {
+		chd.Audit.note(stateBadDump, fn)
+	} else {
+		chd.Audit.note(stateOK, fn)
+	}
+	return true, nil
+}
+
+func (g *Game) checkCHDIn(rompath string, chd *CHD) (bool, string, error) {
+	try := func(dir string) (bool, string, error) {
+		fn := filename_CHD(rompath, g.diskPath(dir), chd.Name)
+		good, err := g.checkCHDFile(fn, chd)
+		if !good {
+			return false, "", err
+		}
+		if want := filepath.Join(rompath, g.diskPath(dir), chd.Name + ".chd"); fn != want {
+			g.Audit.Normalized = append(g.Audit.Normalized, normalizedNote(rompath, fn, want))
 		}
 		return true, fn, nil
 	}
@@ -109,13 +342,52 @@ func (g *Game) checkCHDIn(rompath string, chd *CHD) (bool, string, error) {
 }
 
 // remove all CHDs belonging to this set and its parents from the list
+// the audit of the ones removed is taken from the parent
+// this is only used with old XML files that don't say which CHDs are merged from where
 func (g *Game) strikeCHDs(chds CHDs) {
 	for _, rom := range g.CHDs {
-		delete(chds, rom.Name)
+		name := strings.TrimSpace(rom.Name)
+		if c, ok := chds[name]; ok {
+			c.Audit = rom.Audit
+			if c.Audit.Via == "" {
+				c.Audit.Via = g.Name
+			}
+		}
+		delete(chds, name)
 	}
 	for _, parent := range g.Parents {
-		games[parent].strikeCHDs(chds)
+		g.lookup(parent).strikeCHDs(chds)
+	}
+}
+
+func (g *Game) chdNamed(name string) *CHD {
+	for i := range g.CHDs {
+		if strings.TrimSpace(g.CHDs[i].Name) == name {
+			return &(g.CHDs[i])
+		}
+	}
+	return nil
+}
+
+// follow the merge attributes up the parents to find which game actually has the given CHD
+func (g *Game) chdMergedFrom(c *CHD) (*Game, *CHD) {
+	owner := g
+	for c.Merge != "" {
+		var next *CHD
+		for _, p := range owner.Parents {
+			if pg := owner.lookup(p); pg != nil {
+				if next = pg.chdNamed(strings.TrimSpace(c.Merge)); next != nil {
+					owner = pg
+					break
+				}
+			}
+		}
+		if next == nil {		// merges from nowhere; assume it's ours
+			break
+		}
+		c = next
 	}
+	return owner, c
 }
 
 func (g *Game) findCHDs() (found bool, err error) {
@@ -131,16 +403,37 @@ func (g *Game) findCHDs() (found bool, err error) {
 		}
 	}
 
-	// find the parents and remove their CHDs rom the list
-	for _, parent := range g.Parents {
-		found, err := games[parent].Find()
-		if err != nil {
-			return false, fmt.Errorf("error finding parent %s: %v", parent, err)
+	// find the parents and remove their CHDs from the list
+	// only if there are any to remove, and not for non-merged sets, which have everything themselves and so don't need their parents to be there at all
+	if len(chds) != 0 && setMode != nonMergedSets {
+		for _, parent := range g.Parents {
+			found, err := g.lookup(parent).Find()
+			if err != nil {
+				return false, fmt.Errorf("error finding parent %s: %v", parent, err)
+			}
+			if !found {
+				return false, fmt.Errorf("parent %s not found", parent)
+			}
+			if !g.cat.MergeInfo {
+				g.lookup(parent).strikeCHDs(chds)
+			}
 		}
-		if !found {
-			return false, fmt.Errorf("parent %s not found", parent)
+		if g.cat.MergeInfo {
+			for name, chd := range chds {
+				owner, ochd := g.chdMergedFrom(chd)
+				if owner == g {
+					continue
+				}
+				chd.Audit = ochd.Audit
+				if chd.Audit.Via == "" {
+					chd.Audit.Via = owner.Name
+				}
+				if loc, ok := owner.CHDLoc[strings.TrimSpace(ochd.Name)]; ok {		// so the clone's directory in the mount has it too
+					g.CHDLoc[name] = loc
+				}
+				delete(chds, name)
+			}
 		}
-		games[parent].strikeCHDs(chds)
 	}
 
 	if len(chds) == 0 {		// no CHDs left to check (either has no CHDs or we are done)
@@ -148,8 +441,17 @@ func (g *Game) findCHDs() (found bool, err error) {
 	}
 
 	// go through the directories, finding the right file
-	n := len(chds)
 	for name, chd := range chds {
+		if *useIndex && chd.SHA1 != "" {		// by hash first
+			found, path, err := g.checkCHDIndex(chd)
+			if err != nil {
+				return false, err
+			}
+			if found {
+				g.CHDLoc[name] = path
+				continue
+			}
+		}
 		for _, d := range dirs {
 			found, path, err := g.checkCHDIn(d, chd)
 			if err != nil {
@@ -157,16 +459,17 @@ func (g *Game) findCHDs() (found bool, err error) {
 			}
 			if found {
 				g.CHDLoc[name] = path
-				n--
 				break		// found it in this dir; stop scanning dirs and go to the next CHD
 			}
 		}
 	}
 
-	if n == 0 {		// all found!
-		return true, nil
+	for name, chd := range chds {
+		if _, ok := g.CHDLoc[name]; !ok && chd.Optional != yes {
+			return false, nil
+		}
 	}
 
-	// nope
-	return false, nil
+	// all found!
+	return true, nil
 }
@@ -0,0 +1,30 @@
+// 18 october 2026
+package main
+
+import (
+	"fmt"
+)
+
+// modern MAME machines pull ROMs in from the devices they use, which are listed as machines of their own
+// a machine can't run without the ROMs of its devices, so we need those too
+func (g *Game) findDevices() (found bool, err error) {
+	found = true
+	seen := map[string]bool{}
+	for _, ref := range g.DeviceRefs {
+		d := g.lookup(ref.Name)
+		if d == nil || d == g || seen[ref.Name] {		// not in the XML file (so we can't check it), a reference to ourselves, or a duplicate
+			continue
+		}
+		seen[ref.Name] = true
+		g.Audit.Devices = append(g.Audit.Devices, ref.Name)
+		f, err := d.Find()
+		if err != nil {
+			return false, fmt.Errorf("error finding device %s: %v", ref.Name, err)
+		}
+		if !f {
+			g.problem("device %s not found", ref.Name)
+			found = false		// keep going so the audit lists every missing device
+		}
+	}
+	return found, nil
+}
@@ -4,11 +4,9 @@ package main
 import (
 	"fmt"
 	"os"
-	"io"
 	"path/filepath"
-	"archive/zip"
+	"path"
 	"strconv"
-	"crypto/sha1"
 	"encoding/hex"
 	"bytes"
 	"strings"
@@ -31,87 +29,234 @@ func crc32match(zipcrc uint32, gamecrc string) bool {
 	return uint32(n) == zipcrc
 }
 
-func sha1check(zf *zip.File, expectstring string) (bool, error) {
+func sha1check(af archiveFile, expectstring string) (bool, error) {
 	expected, err := hex.DecodeString(expectstring)
 	if err != nil {
 		log.Fatalf("hex decode error reading sha1 (%q): %v", expectstring, err)
 	}
 
-	f, err := zf.Open()
+	sum, err := af.Sums()
 	if err != nil {
-		return false, fmt.Errorf("could not open given zip file entry: %v", err)
+		return false, err
 	}
-	defer f.Close()
+	got, _ := hex.DecodeString(sum.SHA1)
+	return bytes.Equal(expected, got), nil
+}
 
-	var sha1hash = sha1.New()
+func (g *Game) filename_ROM(rompath string, ext string) string {
+	return normalizedPath(rompath, filepath.Join(g.diskDir(), g.Name + ext))
+}
 
-	n, err := io.Copy(sha1hash, f)
-	if err != nil {
-		return false, fmt.Errorf("could not read given zip file entry: %v", err)
-	}
-	if n != int64(zf.UncompressedSize) {
-		return false, fmt.Errorf("short read from zip file or write to hash but no error returned (expected %d bytes; got %d)", int64(zf.UncompressedSize), n)
-	}
+// how ROM sets are laid out on disk
+const (
+	splitSets		= "split"		// each clone's archive only has the ROMs its parents don't
+	mergedSets	= "merged"	// clones live in their parent's archive
+	nonMergedSets	= "nonmerged"	// every archive has everything
+	autoSets		= "auto"		// try each of the above for every set
+)
 
-	return bytes.Equal(expected, sha1hash.Sum(nil)), nil
-}
+var setMode = splitSets
+
+// what to do about files in an archive that aren't part of the set
+const (
+	strictExtras	= "strict"		// the set is bad
+	ignoreExtras	= "ignore"	// serve the archive anyway; MAME doesn't care
+	filterExtras	= "filter"		// serve a synthesized zip without them
+)
+
+var extrasPolicy = strictExtras
 
-func (g *Game) filename_ROM(rompath string) string {
-	return filepath.Join(rompath, g.Name + ".zip")
+// a good archive for a set
+type archiveCheck struct {
+	filename	string
+	extras	[]string			// entries that don't belong to the set
+	entries	[]*synthEntry		// the entries that do, ready for a synthesized zip
+	renamed	[]string			// names that only matched once normalized, for the audit
 }
 
-func (g *Game) checkIn(rompath string, roms ROMs) (bool, error) {
-	zipname := g.filename_ROM(rompath)
-	f, err := zip.OpenReader(zipname)
-	if os.IsNotExist(err) {		// if the file does not exist, try the next rompath
-		return false, nil
+// if allowed is nil, the archive must contain exactly roms and nothing else (unless extrasPolicy says otherwise)
+// otherwise, entries named in allowed may also be present, and mismatched entries are skipped in case the right one is elsewhere in the archive (merged sets may keep a clone's ROM under clonename/ when its name clashes with one in the parent)
+// returns nil if the archive isn't good
+func (g *Game) checkArchive(filename string, roms ROMs, allowed map[string]bool) (*archiveCheck, error) {
+	f, err := openArchive(filename)
+	if os.IsNotExist(err) {		// if the file does not exist, try the next one
+		return nil, nil
 	}
 	if err != nil {			// something different happened
-		return false, fmt.Errorf("could not open zip file %s: %v", zipname, err)
+		return nil, fmt.Errorf("could not open archive %s: %v", filename, err)
 	}
 	defer f.Close()
 
 	// true values will be written to this as we find valid ROMs
 	// if the length of this does not equal the length of roms when we're done; we missed something and therefore something else is wrong
 	var found = map[string]bool{}
+	var c = &archiveCheck{
+		filename:	filename,
+	}
+	var normROMs map[string]string
+	if len(normalizations) != 0 {
+		normROMs = map[string]string{}
+		for name := range roms {
+			normROMs[normalizeName(name)] = name
+		}
+	}
 
-	for _, file := range f.File {
-		rom, ok := roms[file.Name]
-		if !ok {				// not in archive
-			return false, nil
+	bad := false
+	for _, file := range f.Files() {
+		name := file.Name()
+		rom, ok := roms[name]
+		if !ok && allowed != nil {
+			name = path.Base(name)
+			rom, ok = roms[name]
 		}
-		if file.UncompressedSize != rom.Size {
-			return false, nil
+		renamed := false
+		if !ok && normROMs != nil && !allowed[file.Name()] {
+			name, renamed = normROMs[normalizeName(file.Name())]
+			rom, ok = roms[name]
 		}
-		if !crc32match(file.CRC32, rom.CRC32) {
-			return false, nil
+		if !ok {				// not in archive
+			if !allowed[file.Name()] {
+				c.extras = append(c.extras, file.Name())
+			}
+			continue
 		}
-		good, err := sha1check(file, rom.SHA1)
+		// keep going even if this one's bad so the audit knows about everything
+		state, err := romState(file, rom)
 		if err != nil {
-			return false, fmt.Errorf("could not calculate SHA-1 sum of %s in %s: %v", g.Name, zipname, err)
+			return nil, fmt.Errorf("could not verify %s in %s: %v", file.Name(), filename, err)
 		}
-		if !good {
-			return false, nil
+		rom.Audit.note(state, filename)
+		if state < stateBadDump {
+			if allowed == nil {
+				bad = true
+			}
+			continue
 		}
-		found[file.Name] = true		// mark as done
+		if !found[name] {
+			if renamed {
+				c.renamed = append(c.renamed, fmt.Sprintf("%s for %s", file.Name(), name))
+			}
+			crc, _ := file.CRC32()		// already worked in romState()
+			c.entries = append(c.entries, &synthEntry{
+				name:	name,
+				size:		rom.Size,
+				crc:		crc,
+				src:		romLocation{
+					archive:	filename,
+					entry:	file.Name(),
+				},
+			})
+		}
+		found[name] = true		// mark as done
 	}
 
+	if bad || (len(c.extras) != 0 && extrasPolicy == strictExtras) {
+		return nil, nil
+	}
 	// if we reached here everything we know about checked out, so if there are any leftover files in the game, that means something is wrong
-	return len(roms) == len(found), nil
+	for name, rom := range roms {
+		if !found[name] && !rom.isOptional() {
+			return nil, nil
+		}
+	}
+	return c, nil
+}
+
+func romState(file archiveFile, rom *ROM) (auditState, error) {
+	if file.Size() != rom.Size {
+		return stateWrongSize, nil
+	}
+	crc, err := file.CRC32()
+	if err != nil {
+		return stateMissing, fmt.Errorf("could not get CRC-32: %v", err)
+	}
+	if !crc32match(crc, rom.CRC32) {
+		return stateWrongCRC, nil
+	}
+	if rom.Status == baddump {		// there's no one right answer for a bad dump, so the CRC-32 is good enough
+		return stateBadDump, nil
+	}
+	if rom.SHA1 == "" {		// old XML files don't always have one
+		return stateOK, nil
+	}
+	good, err := sha1check(file, rom.SHA1)
+	if err != nil {
+		return stateMissing, fmt.Errorf("could not calculate SHA-1 sum: %v", err)
+	}
+	if !good {
+		return stateWrongSHA1, nil
+	}
+	return stateOK, nil
+}
+
+func romcheck(file archiveFile, rom *ROM) (bool, error) {
+	state, err := romState(file, rom)
+	return state >= stateBadDump, err
+}
+
+func (g *Game) checkIn(rompath string, roms ROMs, allowed map[string]bool) (*archiveCheck, error) {
+	for _, ext := range archiveExts {
+		filename := g.filename_ROM(rompath, ext)
+		c, err := g.checkArchive(filename, roms, allowed)
+		if want := filepath.Join(rompath, g.diskDir(), g.Name + ext); c != nil && filename != want {
+			c.renamed = append([]string{normalizedNote(rompath, filename, want)}, c.renamed...)
+		}
+		if err != nil || c != nil {
+			return c, err
+		}
+	}
+	return nil, nil
+}
+
+// go through the directories, finding the right file
+func (g *Game) search(roms ROMs, allowed map[string]bool) (*archiveCheck, error) {
+	for _, d := range dirs {
+		c, err := g.checkIn(d, roms, allowed)
+		if err != nil || c != nil {
+			return c, err
+		}
+	}
+	return nil, nil
+}
+
+// serve the archive search() found (if any) for g
+func (g *Game) useArchive(c *archiveCheck) bool {
+	if c == nil {
+		return false
+	}
+	g.ROMLoc = c.filename
+	g.Extras = c.extras
+	g.Audit.Normalized = append(g.Audit.Normalized, c.renamed...)
+	if len(c.extras) != 0 {
+		log.Printf("game %s: unexpected files in %s: %s\n", g.Name, c.filename, strings.Join(c.extras, ", "))
+		if extrasPolicy == filterExtras {
+			g.Synth = newSynthZip(c.entries)
+		}
+	}
+	return true
 }
 
 // remove all ROMs belonging to this set and its parents from the list
+// the audit of the ones removed is taken from the parent
+// this is only used with old XML files that don't say which ROMs are merged from where
 func (g *Game) strikeROMs(roms ROMs) {
 	for _, rom := range g.ROMs {
-		delete(roms, rom.Name)
+		name := strings.TrimSpace(rom.Name)
+		if r, ok := roms[name]; ok {
+			r.Audit = rom.Audit
+			if r.Audit.Via == "" {
+				r.Audit.Via = g.Name
+			}
+		}
+		delete(roms, name)
 	}
 	for _, parent := range g.Parents {
-		games[parent].strikeROMs(roms)
+		g.lookup(parent).strikeROMs(roms)
 	}
 }
 
-func (g *Game) findROMs() (found bool, err error) {
-	// populate list of ROMs
+// populate list of ROMs
+func (g *Game) romList() ROMs {
 	var roms = make(ROMs)
 	for i := range g.ROMs {
 		if g.ROMs[i].Status != nodump {	// otherwise games with known undumped ROMs will return "not found" because the map never depletes
@@ -120,35 +265,320 @@ func (g *Game) findROMs() (found bool, err error) {
 			roms[strings.TrimSpace(g.ROMs[i].Name)] = &(g.ROMs[i])
 		}
 	}
+	return roms
+}
 
-	// find the parents and remove their ROMs rom the list
-	for _, parent := range g.Parents {
-		found, err := games[parent].Find()
+func (g *Game) romNamed(name string) *ROM {
+	for i := range g.ROMs {
+		if strings.TrimSpace(g.ROMs[i].Name) == name {
+			return &(g.ROMs[i])
+		}
+	}
+	return nil
+}
+
+// follow the merge attributes up the parents to find which game actually has the given ROM
+func (g *Game) mergedFrom(r *ROM) (*Game, *ROM) {
+	owner := g
+	for r.Merge != "" {
+		var next *ROM
+		for _, p := range owner.Parents {
+			if pg := owner.lookup(p); pg != nil {
+				if next = pg.romNamed(strings.TrimSpace(r.Merge)); next != nil {
+					owner = pg
+					break
+				}
+			}
+		}
+		if next == nil {		// merges from nowhere; assume it's ours
+			break
+		}
+		r = next
+	}
+	return owner, r
+}
+
+// find the given parents and remove the ROMs they provide from the list
+func (g *Game) strikeParents(roms ROMs, parents []string) error {
+	providers := map[*Game]bool{}
+	for _, parent := range parents {
+		found, err := g.lookup(parent).Find()
 		if err != nil {
-			return false, fmt.Errorf("error finding parent %s: %v", parent, err)
+			return fmt.Errorf("error finding parent %s: %v", parent, err)
 		}
 		if !found {
-			return false, fmt.Errorf("parent %s not found", parent)
+			return fmt.Errorf("parent %s not found", parent)
+		}
+		if !g.cat.MergeInfo {
+			g.lookup(parent).strikeROMs(roms)
+		}
+		for _, a := range g.lookup(parent).ancestors() {
+			providers[a] = true
 		}
-		games[parent].strikeROMs(roms)
 	}
+	if !g.cat.MergeInfo {
+		return nil
+	}
+	for name, rom := range roms {
+		owner, orom := g.mergedFrom(rom)
+		if providers[owner] {
+			rom.Audit = orom.Audit
+			if rom.Audit.Via == "" {
+				rom.Audit.Via = owner.Name
+			}
+			delete(roms, name)
+		}
+	}
+	return nil
+}
+
+// the parents that are not part of the clone chain; usually the BIOS
+// non-merged and merged sets normally still keep these in their own archives
+func (g *Game) biosParents() (bios []string) {
+	for a := g; a != nil; a = g.lookup(a.CloneOf) {
+		if a.ROMOf != "" && a.ROMOf != a.CloneOf {
+			bios = append(bios, a.ROMOf)
+		}
+		if a.CloneOf == "" {
+			break
+		}
+	}
+	return bios
+}
+
+// the top of the clone chain, where merged sets keep everything
+func (g *Game) mergedRoot() *Game {
+	root := g
+	for root.CloneOf != "" && g.lookup(root.CloneOf) != nil {
+		root = g.lookup(root.CloneOf)
+	}
+	return root
+}
 
+// names of all ROMs that can appear in g's archive if g is a merged set
+func (g *Game) mergedNames(names map[string]bool, prefix string) map[string]bool {
+	if names == nil {
+		names = map[string]bool{}
+	}
+	for name := range g.romList() {
+		names[name] = true
+		names[prefix + name] = true
+	}
+	for _, c := range g.Clones {
+		g.lookup(c).mergedNames(names, c + "/")
+	}
+	return names
+}
+
+func (g *Game) findSplit() (found bool, err error) {
+	roms := g.romList()
+	err = g.strikeParents(roms, g.Parents)
+	if err != nil {
+		return false, err
+	}
 	if len(roms) == 0 {		// no ROMs left to check (either has no ROMs or is just a CHD after BIOSes)
 		return true, nil
 	}
+	c, err := g.search(roms, nil)
+	return g.useArchive(c), err
+}
 
-	// go through the directories, finding the right file
-	for _, d := range dirs {
-		found, err := g.checkIn(d, roms)
+func (g *Game) findNonMerged() (found bool, err error) {
+	// first try with everything, BIOS included
+	roms := g.romList()
+	if len(roms) == 0 {
+		return true, nil
+	}
+	c, err := g.search(roms, nil)
+	if c != nil || err != nil {
+		return g.useArchive(c), err
+	}
+
+	// then with the BIOS in an archive of its own
+	bios := g.biosParents()
+	if len(bios) == 0 {
+		return false, nil
+	}
+	err = g.strikeParents(roms, bios)
+	if err != nil {
+		return false, err
+	}
+	if len(roms) == 0 {
+		return true, nil
+	}
+	c, err = g.search(roms, nil)
+	return g.useArchive(c), err
+}
+
+func (g *Game) findMerged() (found bool, err error) {
+	roms := g.romList()
+	err = g.strikeParents(roms, g.biosParents())
+	if err != nil {
+		return false, err
+	}
+	if len(roms) == 0 {
+		return true, nil
+	}
+	root := g.mergedRoot()
+	c, err := root.search(roms, root.mergedNames(nil, root.Name + "/"))
+	return g.useArchive(c), err
+}
+
+// g and everything it depends on, nearest first
+func (g *Game) ancestors() []*Game {
+	var list = []*Game{g}
+	seen := map[string]bool{g.Name: true}
+	for i := 0; i < len(list); i++ {
+		for _, p := range list[i].Parents {
+			if !seen[p] && g.lookup(p) != nil {
+				seen[p] = true
+				list = append(list, g.lookup(p))
+			}
+		}
+	}
+	return list
+}
+
+// look for a single ROM in every archive that might have it: anywhere the ROM index says, or without an index the game's own, its parents', and those of any other game that has a ROM with the same SHA-1
+// archives we've already opened are kept in opened (nil if not there) so we don't open them once per ROM
+func (g *Game) findAnywhere(rom *ROM, opened map[string]archive) (*synthEntry, error) {
+	if romIndex != nil {
+		for _, l := range lookupROM(rom) {
+			crc, _ := strconv.ParseUint(rom.CRC32, 16, 32)		// lookupROM() already checked it
+			rom.Audit.note(stateOK, l.archive)
+			return &synthEntry{
+				size:		rom.Size,
+				crc:		uint32(crc),
+				src:		l,
+			}, nil
+		}
+		// not indexed (a loose set, say); fall through to looking where it should be
+	}
+
+	candidates := g.ancestors()
+	candidates = append(candidates, romsBySHA1[strings.ToLower(rom.SHA1)]...)
+	for _, c := range candidates {
+		for _, d := range dirs {
+			for _, ext := range archiveExts {
+				filename := c.filename_ROM(d, ext)
+				a, ok := opened[filename]
+				if !ok {
+					var err error
+					a, err = openArchive(filename)
+					if os.IsNotExist(err) {
+						a = nil
+					} else if err != nil {
+						return nil, fmt.Errorf("could not open archive %s: %v", filename, err)
+					}
+					opened[filename] = a
+				}
+				if a == nil {
+					continue
+				}
+				for _, file := range a.Files() {
+					state, err := romState(file, rom)
+					if err != nil {
+						return nil, fmt.Errorf("could not verify %s in %s: %v", file.Name(), filename, err)
+					}
+					if normalizeName(path.Base(file.Name())) == normalizeName(strings.TrimSpace(rom.Name)) || state >= stateBadDump {
+						rom.Audit.note(state, filename)
+					}
+					if state >= stateBadDump {
+						crc, _ := file.CRC32()		// already worked in romState()
+						return &synthEntry{
+							size:		rom.Size,
+							crc:		crc,
+							src:		romLocation{
+								archive:	filename,
+								entry:	file.Name(),
+							},
+						}, nil
+					}
+				}
+			}
+		}
+	}
+	return nil, nil
+}
+
+// build a synthesized zip with every ROM the game needs, wherever they are
+func (g *Game) findScattered() (found bool, err error) {
+	opened := map[string]archive{}
+	defer func() {
+		for _, a := range opened {
+			if a != nil {
+				a.Close()
+			}
+		}
+	}()
+
+	var entries []*synthEntry
+	for name, rom := range g.romList() {
+		e, err := g.findAnywhere(rom, opened)
 		if err != nil {
 			return false, err
 		}
-		if found {
-			g.ROMLoc = g.filename_ROM(d)
-			return true, nil
+		if e == nil {
+			if rom.isOptional() {
+				continue
+			}
+			return false, nil
 		}
+		e.name = name
+		entries = append(entries, e)
 	}
+	g.Synth = newSynthZip(entries)
+	return true, nil
+}
 
-	// nope
-	return false, nil
+var synthesize = true
+
+// whether any set might be served as a synthesized zip
+func canSynthesize() bool {
+	return synthesize || *useIndex || extrasPolicy == filterExtras
+}
+
+func (g *Game) findROMs() (found bool, err error) {
+	found, err = g.findLayout()
+	// the ROM index finds ROMs by content wherever they are, and those can only be served in a synthesized zip, so it implies -synthesize
+	if found || (!synthesize && romIndex == nil) {
+		return found, err
+	}
+	synthFound, synthErr := g.findScattered()
+	if synthErr != nil {
+		return false, synthErr
+	}
+	if synthFound {
+		g.ROMLoc = ""
+		g.Extras = nil
+		return true, nil
+	}
+	return found, err
+}
+
+func (g *Game) findLayout() (found bool, err error) {
+	switch setMode {
+	case splitSets:
+		return g.findSplit()
+	case nonMergedSets:
+		return g.findNonMerged()
+	case mergedSets:
+		return g.findMerged()
+	}
+
+	// auto: the first layout that works wins; if none do, report why split didn't since that's what MAME itself expects
+	found, err = g.findSplit()
+	if found {
+		return true, nil
+	}
+	for _, try := range []func() (bool, error){ g.findNonMerged, g.findMerged } {
+		f, e := try()
+		if f && e == nil {
+			return true, nil
+		}
+	}
+	g.ROMLoc = ""
+	g.Extras = nil
+	g.Synth = nil
+	return false, err
 }
@@ -0,0 +1,90 @@
+// 18 october 2026
+package main
+
+import (
+	"testing"
+	"os"
+	"sort"
+	"archive/zip"
+	"path/filepath"
+	"code.google.com/p/rsc/fuse"
+)
+
+// the ROMs in testdata/sets/sets.xml are each "ROM " and the letter at the start of their name
+var testROMs = map[string]string{
+	"a.bin":	"ROM A",
+	"b.bin":	"ROM B",
+	"c.bin":	"ROM C",
+	"d.bin":	"ROM D",
+}
+
+// load testdata/sets/sets.xml, find sets in dir and nowhere else, and restore the options tests change afterwards
+func loadTestSets(t *testing.T, dir string) map[string]*Game {
+	oldDirs, oldMode, oldSynth, oldExtras := dirs, setMode, synthesize, extrasPolicy
+	t.Cleanup(func() {
+		dirs, setMode, synthesize, extrasPolicy = oldDirs, oldMode, oldSynth, oldExtras
+		archivesOnDisk = map[string]map[string]bool{}
+	})
+	dirs = []string{dir}
+	archivesOnDisk = map[string]map[string]bool{}
+	g := loadTestCatalog(t, "testdata/sets/sets.xml")
+	machines.prepare(new(fuse.Tree))
+	return g
+}
+
+func writeZip(t *testing.T, filename string, names ...string) {
+	f, err := os.Create(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	w := zip.NewWriter(f)
+	sort.Strings(names)
+	for _, name := range names {
+		fw, err := w.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		fw.Write([]byte(testROMs[name]))
+	}
+	err = w.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// a non-merged clone has everything it needs, so its parent doesn't have to be there
+func TestNonMergedCloneWithoutParent(t *testing.T) {
+	dir := t.TempDir()
+	writeZip(t, filepath.Join(dir, "clone.zip"), "a.bin", "c.bin")
+	g := loadTestSets(t, dir)
+	setMode = nonMergedSets
+	synthesize = false
+	clone := testGame(t, g, "clone")
+	found, err := clone.Find()
+	if err != nil || !found {
+		t.Fatalf("clone not found (%v): %s", err, clone.auditSummary())
+	}
+	if clone.ROMLoc != filepath.Join(dir, "clone.zip") {
+		t.Errorf("clone served from %q", clone.ROMLoc)
+	}
+	if found, _ := testGame(t, g, "parent").Find(); found {
+		t.Errorf("parent found without being there")
+	}
+}
+
+// if a set's ROMs are there but its disk isn't, nothing of it is served
+func TestNotFoundServesNothing(t *testing.T) {
+	dir := t.TempDir()
+	writeZip(t, filepath.Join(dir, "withdisk.zip"), "d.bin")
+	g := loadTestSets(t, dir)
+	synthesize = false
+	withdisk := testGame(t, g, "withdisk")
+	found, err := withdisk.Find()
+	if err != nil || found {
+		t.Fatalf("set found without its disk (%v)", err)
+	}
+	if withdisk.ROMLoc != "" || withdisk.Synth != nil {
+		t.Errorf("set not found still has ROMs at %q (synthesized: %v)", withdisk.ROMLoc, withdisk.Synth != nil)
+	}
+}
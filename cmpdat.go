@@ -0,0 +1,344 @@
+// 18 october 2026
+package main
+
+import (
+	"fmt"
+	"io"
+	"bufio"
+	"strconv"
+	"strings"
+	"unicode"
+	"log"
+)
+
+// ClrMamePro's own DAT format (also what MAME's old -listinfo gave) is text instead of XML:
+//	clrmamepro ( name "Some Collection" version 20130101 )
+//	game ( name pacman cloneof puckman romof puckman rom ( name pacman.6e size 4096 crc c1e6ab10 sha1 ... ) )
+// each block is keys followed by their values, which are either words, "quoted strings" (with \" for a "), or more blocks; a few flags like nodump stand alone
+// resource is what old DATs called a BIOS
+
+// a word, or a ( ... ) if block isn't nil
+type cmpItem struct {
+	word	string
+	block	[]cmpItem
+}
+
+type cmpParser struct {
+	r	*bufio.Reader
+	line	int
+}
+
+// if it starts with a word and then a (, it's one of these and not XML
+func isCMPDat(b []byte) bool {
+	s := catalogStart(b)
+	word := strings.TrimLeftFunc(s, func(r rune) bool {
+		return unicode.IsLetter(r)
+	})
+	return len(word) != len(s) && strings.HasPrefix(strings.TrimLeft(word, " \t\r\n"), "(")
+}
+
+func (p *cmpParser) token() (tok string, quoted bool, err error) {
+	c, err := p.skipSpace()
+	if err != nil {
+		return "", false, err
+	}
+	switch c {
+	case '(', ')':
+		return string(c), false, nil
+	case '"':
+		var b strings.Builder
+		for {
+			c, _, err := p.r.ReadRune()
+			if err == io.EOF {
+				return "", false, fmt.Errorf("line %d: file ends inside a quoted string", p.line)
+			} else if err != nil {
+				return "", false, err
+			}
+			if c == '"' {
+				return b.String(), true, nil
+			}
+			if c == '\\' {		// like ClrMamePro, a backslash takes whatever comes after it as is, so \" and \\ are " and \
+				c, _, err = p.r.ReadRune()
+				if err == io.EOF {
+					return "", false, fmt.Errorf("line %d: file ends inside a quoted string", p.line)
+				} else if err != nil {
+					return "", false, err
+				}
+			}
+			if c == '\n' {
+				p.line++
+			}
+			b.WriteRune(c)
+		}
+	}
+	var b strings.Builder
+	b.WriteRune(c)
+	for {
+		c, _, err := p.r.ReadRune()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return "", false, err
+		}
+		if unicode.IsSpace(c) || c == '(' || c == ')' {
+			p.r.UnreadRune()
+			break
+		}
+		b.WriteRune(c)
+	}
+	return b.String(), false, nil
+}
+
+func (p *cmpParser) skipSpace() (rune, error) {
+	for {
+		c, _, err := p.r.ReadRune()
+		if err != nil {
+			return 0, err
+		}
+		if c == '\n' {
+			p.line++
+		}
+		if !unicode.IsSpace(c) {
+			return c, nil
+		}
+	}
+}
+
+// read items up to the ) that ends the block, or the end of the file at the top level
+func (p *cmpParser) block(top bool) ([]cmpItem, error) {
+	items := []cmpItem{}		// not nil, since that means a word
+	for {
+		tok, quoted, err := p.token()
+		if err == io.EOF {
+			if top {
+				return items, nil
+			}
+			return nil, fmt.Errorf("line %d: file ends inside a block", p.line)
+		} else if err != nil {
+			return nil, err
+		}
+		switch {
+		case !quoted && tok == "(":
+			b, err := p.block(false)
+			if err != nil {
+				return nil, err
+			}
+			items = append(items, cmpItem{
+				block:	b,
+			})
+		case !quoted && tok == ")":
+			if top {
+				return nil, fmt.Errorf("line %d: ) without (", p.line)
+			}
+			return items, nil
+		default:
+			items = append(items, cmpItem{
+				word:	tok,
+			})
+		}
+	}
+}
+
+// the keys that don't take a value
+var cmpFlags = map[string]bool{
+	nodump:	true,
+	baddump:	true,
+}
+
+// call f with each key and its value
+func cmpFields(items []cmpItem, f func(key string, value cmpItem) error) error {
+	for i := 0; i < len(items); i++ {
+		if items[i].block != nil {
+			return fmt.Errorf("( ... ) where a key should be")
+		}
+		key := strings.ToLower(items[i].word)
+		var value cmpItem
+		if !cmpFlags[key] {
+			i++
+			if i == len(items) {
+				return fmt.Errorf("no value for %s", key)
+			}
+			value = items[i]
+		}
+		err := f(key, value)
+		if err != nil && value.block == nil {
+			return fmt.Errorf("%s: %v", key, err)
+		} else if err != nil {		// blocks say which one themselves
+			return err
+		}
+	}
+	return nil
+}
+
+func (i cmpItem) wantBlock() error {
+	if i.block == nil {
+		return fmt.Errorf("expected ( ... ), got %q", i.word)
+	}
+	return nil
+}
+
+func readCMPDat(r io.Reader) error {
+	p := &cmpParser{
+		r:		bufio.NewReader(r),
+		line:		1,
+	}
+	items, err := p.block(true)
+	if err != nil {
+		return err
+	}
+	return cmpFields(items, func(key string, value cmpItem) error {
+		if err := value.wantBlock(); err != nil {
+			return err
+		}
+		switch key {
+		case "clrmamepro", "emulator":
+			var name, description, version string
+			err := cmpFields(value.block, func(key string, value cmpItem) error {
+				switch key {
+				case "name":
+					name = value.word
+				case "description":
+					description = value.word
+				case "version":
+					version = value.word
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+			log.Printf("loading DAT %s (%s) version %s\n", name, description, version)
+		case "game", "machine", "resource":
+			g, err := cmpGame(value.block)
+			if err != nil {
+				return err
+			}
+			if key == "resource" {
+				g.IsBIOS = yes
+			}
+			games[g.Name] = g
+		}
+		return nil
+	})
+}
+
+func cmpGame(items []cmpItem) (*Game, error) {
+	g := new(Game)
+	err := cmpFields(items, func(key string, value cmpItem) error {
+		switch key {
+		case "name":
+			g.Name = value.word
+		case "cloneof":
+			g.CloneOf = value.word
+		case "romof":
+			g.ROMOf = value.word
+		case "sampleof":
+			g.SampleOf = value.word
+		case "sample":
+			g.Samples = append(g.Samples, Sample{
+				Name:	value.word,
+			})
+		case "rom":
+			if err := value.wantBlock(); err != nil {
+				return err
+			}
+			r, err := cmpROM(value.block)
+			if err != nil {
+				return err
+			}
+			g.ROMs = append(g.ROMs, *r)
+		case "disk":
+			if err := value.wantBlock(); err != nil {
+				return err
+			}
+			c, err := cmpCHD(value.block)
+			if err != nil {
+				return err
+			}
+			g.CHDs = append(g.CHDs, *c)
+		case "biosset":
+			if err := value.wantBlock(); err != nil {
+				return err
+			}
+			var b BIOSSet
+			err := cmpFields(value.block, func(key string, value cmpItem) error {
+				switch key {
+				case "name":
+					b.Name = value.word
+				case "description":
+					b.Description = value.word
+				case "default":
+					b.Default = value.word
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+			g.BIOSSets = append(g.BIOSSets, b)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("game %s: %v", g.Name, err)
+	}
+	return g, nil
+}
+
+func cmpROM(items []cmpItem) (*ROM, error) {
+	r := new(ROM)
+	err := cmpFields(items, func(key string, value cmpItem) error {
+		switch key {
+		case "name":
+			r.Name = value.word
+		case "size":
+			n, err := strconv.ParseUint(value.word, 10, 64)
+			if err != nil {
+				return err
+			}
+			r.Size = n
+		case "crc":
+			r.CRC32 = value.word
+		case "sha1":
+			r.SHA1 = value.word
+		case "merge":
+			r.Merge = value.word
+		case "bios":
+			r.BIOS = value.word
+		case "flags", "status":
+			r.Status = value.word
+		case nodump, baddump:
+			r.Status = key
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("rom %s: %v", r.Name, err)
+	}
+	return r, nil
+}
+
+func cmpCHD(items []cmpItem) (*CHD, error) {
+	c := new(CHD)
+	err := cmpFields(items, func(key string, value cmpItem) error {
+		switch key {
+		case "name":
+			c.Name = value.word
+		case "sha1":
+			c.SHA1 = value.word
+		case "md5":
+			c.MD5 = value.word
+		case "merge":
+			c.Merge = value.word
+		case "flags", "status":
+			c.Status = value.word
+		case nodump, baddump:
+			c.Status = key
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("disk %s: %v", c.Name, err)
+	}
+	return c, nil
+}
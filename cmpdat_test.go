@@ -0,0 +1,78 @@
+// 18 october 2026
+package main
+
+import (
+	"testing"
+	"bytes"
+	"bufio"
+	"log"
+	"os"
+	"strings"
+)
+
+func TestCMPDat(t *testing.T) {
+	var logged bytes.Buffer
+	log.SetOutput(&logged)
+	defer log.SetOutput(os.Stderr)
+	g := loadTestCatalog(t, "testdata/cmpdat/mame.dat")
+	if len(g) != 4 {
+		t.Errorf("%d sets loaded, want 4", len(g))
+	}
+	if !strings.Contains(logged.String(), "loading DAT Test \"Arcade\" DAT (a few sets, the way old -listinfo gave them) version 0.106") {
+		t.Errorf("header not logged right; log was %q", logged.String())
+	}
+	if neogeo := testGame(t, g, "neogeo"); neogeo.IsBIOS != yes || len(neogeo.ROMs) != 2 || neogeo.ROMs[1].SHA1 != "42f9d7ddd6c0931fd64226a60dc73602b2819dcf" {
+		t.Errorf("wrong resource %+v", neogeo)
+	}
+	kof98 := testGame(t, g, "kof98")
+	if kof98.IsBIOS != "" || kof98.ROMOf != "neogeo" || len(kof98.ROMs) != 3 {
+		t.Fatalf("wrong kof98 %+v", kof98)
+	}
+	if rom := kof98.ROMs[1]; rom.Status != nodump || rom.Size != 8388608 {
+		t.Errorf("wrong nodump ROM %+v", rom)
+	}
+	if rom := kof98.ROMs[2]; rom.Merge != "neo-geo.rom" || rom.Status != "" {
+		t.Errorf("wrong merged ROM %+v", rom)
+	}
+	kof98a := testGame(t, g, "kof98a")
+	if kof98a.CloneOf != "kof98" || kof98a.ROMOf != "kof98" || len(kof98a.ROMs) != 1 || kof98a.ROMs[0].Status != baddump {
+		t.Errorf("wrong kof98a %+v", kof98a)
+	}
+	area51 := testGame(t, g, "area51")
+	if len(area51.CHDs) != 1 || area51.CHDs[0].Name != "area51" || area51.CHDs[0].SHA1 != "3b303bc37e206a6d7339352c869f050d04186f11" {
+		t.Errorf("wrong disk %+v", area51.CHDs)
+	}
+}
+
+func TestCMPQuoted(t *testing.T) {
+	for _, tc := range []struct {
+		in	string
+		want	string
+	}{
+		{`"plain"`,			`plain`},
+		{`"a \"quote\" in it"`,		`a "quote" in it`},
+		{`"back\\slash"`,		`back\slash`},
+		{`"ends with \\"`,		`ends with \`},
+		{`"\x"`,				`x`},
+	} {
+		p := &cmpParser{
+			r:		bufio.NewReader(strings.NewReader(tc.in + " next")),
+			line:		1,
+		}
+		tok, quoted, err := p.token()
+		if err != nil || !quoted || tok != tc.want {
+			t.Errorf("%s gave %q, %v, %v, want %q", tc.in, tok, quoted, err, tc.want)
+			continue
+		}
+		if tok, _, _ = p.token(); tok != "next" {
+			t.Errorf("%s: next token is %q, want next", tc.in, tok)
+		}
+	}
+	p := &cmpParser{
+		r:		bufio.NewReader(strings.NewReader(`"never \"ends`)),
+		line:		1,
+	}
+	if _, _, err := p.token(); err == nil {
+		t.Errorf("unterminated string accepted")
+	}
+}
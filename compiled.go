@@ -0,0 +1,424 @@
+// 18 october 2026
+package main
+
+import (
+	"fmt"
+	"os"
+	"bytes"
+	"sort"
+	"encoding/binary"
+	"encoding/hex"
+	"path/filepath"
+	"code.google.com/p/rsc/fuse"
+	"log"
+)
+
+// decoding all of -listxml every time we start takes a long time and a lot of memory, so the compile command saves the catalog in a form that loads almost instantly
+// the compiled catalog remembers the full path, size, and modification time of what it was made from, and is made again whenever those change; the path matters when -catalogindex is shared between catalogs
+// the format, all numbers uvarints:
+//	magic, version
+//	source path: length, then bytes
+//	source size, source modification time (nanoseconds since the epoch)
+//	string table: count, then each string as length and bytes; everything else refers to strings by their index here, so the thousands of repeated names, statuses, and such are stored once
+//	catalogs: count, then each catalog (the main one first, then any software lists that came from the same file): dir, game count, games
+// hashes are stored as the bytes they stand for if they can be and would come back exactly the same (so not uppercase), or else as strings
+// parents (cloneof and romof) are stored by name like everything else and turned into the parent graph by Catalog.prepare() when loaded, same as with XML
+
+var catalogIndex = ""
+
+const (
+	compiledMagic	= 0x4D464349		// MFCI
+	compiledVersion	= 3
+)
+
+// where the compiled form of the given catalog goes
+func compiledFilename(filename string) string {
+	if catalogIndex != "" {
+		return catalogIndex
+	}
+	return filename + ".mfidx"
+}
+
+// how the compiled form names what it was made from
+func compiledSource(filename string) string {
+	abs, err := filepath.Abs(filename)
+	if err != nil {		// only if we can't get the current directory; filename is still better than nothing
+		return filename
+	}
+	return abs
+}
+
+type compiledWriter struct {
+	body		bytes.Buffer
+	strings	map[string]uint64
+	table	[]string
+}
+
+func (w *compiledWriter) uint(n uint64) {
+	var b [binary.MaxVarintLen64]byte
+	w.body.Write(b[:binary.PutUvarint(b[:], n)])
+}
+
+func (w *compiledWriter) string(s string) {
+	n, ok := w.strings[s]
+	if !ok {
+		n = uint64(len(w.table))
+		w.strings[s] = n
+		w.table = append(w.table, s)
+	}
+	w.uint(n)
+}
+
+// how a hash is stored
+const (
+	hashNone = iota
+	hashBytes
+	hashString		// not valid hex of the right length; kept as is
+)
+
+func (w *compiledWriter) hash(s string, size int) {
+	b, err := hex.DecodeString(s)
+	switch {
+	case s == "":
+		w.uint(hashNone)
+	case err == nil && len(b) == size && hex.EncodeToString(b) == s:
+		w.uint(hashBytes)
+		w.body.Write(b)
+	default:
+		w.uint(hashString)
+		w.string(s)
+	}
+}
+
+func (w *compiledWriter) game(g *Game) {
+	for _, s := range []string{g.Name, g.CloneOf, g.ROMOf, g.SampleOf, g.IsDevice, g.IsBIOS, g.Runnable, g.folder} {
+		w.string(s)
+	}
+	w.uint(uint64(len(g.ROMs)))
+	for _, r := range g.ROMs {
+		w.string(r.Name)
+		w.uint(r.Size)
+		w.hash(r.CRC32, 4)
+		w.hash(r.SHA1, 20)
+		for _, s := range []string{r.Status, r.Merge, r.Optional, r.BIOS} {
+			w.string(s)
+		}
+	}
+	w.uint(uint64(len(g.CHDs)))
+	for _, c := range g.CHDs {
+		w.string(c.Name)
+		w.hash(c.SHA1, 20)
+		w.hash(c.MD5, 16)
+		for _, s := range []string{c.Status, c.Merge, c.Optional} {
+			w.string(s)
+		}
+	}
+	w.uint(uint64(len(g.BIOSSets)))
+	for _, b := range g.BIOSSets {
+		w.string(b.Name)
+		w.string(b.Description)
+		w.string(b.Default)
+	}
+	w.uint(uint64(len(g.DeviceRefs)))
+	for _, d := range g.DeviceRefs {
+		w.string(d.Name)
+	}
+	w.uint(uint64(len(g.Samples)))
+	for _, s := range g.Samples {
+		w.string(s.Name)
+	}
+}
+
+func (w *compiledWriter) catalog(c *Catalog) {
+	w.string(c.Dir)
+	names := make([]string, 0, len(c.Games))
+	for name := range c.Games {
+		names = append(names, name)
+	}
+	sort.Strings(names)		// so the same catalog always compiles the same
+	w.uint(uint64(len(names)))
+	for _, name := range names {
+		w.game(c.Games[name])
+	}
+}
+
+// write out machines and softwareLists as they are right after reading filename
+func writeCompiled(filename string, fi os.FileInfo) error {
+	w := &compiledWriter{
+		strings:	map[string]uint64{},
+	}
+	var lists []string
+	for name := range softwareLists {
+		lists = append(lists, name)
+	}
+	sort.Strings(lists)
+	w.uint(uint64(1 + len(lists)))
+	w.catalog(machines)
+	n := len(machines.Games)
+	for _, name := range lists {
+		w.catalog(softwareLists[name])
+		n += len(softwareLists[name].Games)
+	}
+
+	var out bytes.Buffer
+	head := &compiledWriter{
+		strings:	w.strings,
+	}
+	head.uint(compiledMagic)
+	head.uint(compiledVersion)
+	source := compiledSource(filename)
+	head.uint(uint64(len(source)))
+	head.body.WriteString(source)
+	head.uint(uint64(fi.Size()))
+	head.uint(uint64(fi.ModTime().UnixNano()))
+	head.uint(uint64(len(w.table)))
+	for _, s := range w.table {
+		head.uint(uint64(len(s)))
+		head.body.WriteString(s)
+	}
+	out.Write(head.body.Bytes())
+	out.Write(w.body.Bytes())
+
+	// write to a temporary file first so a crash halfway through doesn't leave a broken catalog behind
+	dest := compiledFilename(filename)
+	f, err := os.CreateTemp(filepath.Dir(dest), filepath.Base(dest) + ".*")
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(out.Bytes())
+	if err == nil {
+		err = f.Chmod(0644)		// not just ours like temporary files are
+	}
+	if err == nil {
+		err = f.Close()
+	} else {
+		f.Close()
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), dest)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	log.Printf("compiled %s into %s (%d sets, %d strings, %d bytes)\n", filename, dest, n, len(w.table), out.Len())
+	return nil
+}
+
+type compiledReader struct {
+	data		[]byte
+	table	[]string
+	err		error		// the first thing to go wrong; everything returns zero after that
+}
+
+func (r *compiledReader) fail(format string, args ...interface{}) {
+	if r.err == nil {
+		r.err = fmt.Errorf(format, args...)
+	}
+	r.data = nil
+}
+
+func (r *compiledReader) uint() uint64 {
+	if r.err != nil {
+		return 0
+	}
+	n, k := binary.Uvarint(r.data)
+	if k <= 0 {
+		r.fail("truncated")
+		return 0
+	}
+	r.data = r.data[k:]
+	return n
+}
+
+// a count of things that each take at least one byte, so a damaged file can't make us allocate the world
+func (r *compiledReader) count() int {
+	n := r.uint()
+	if n > uint64(len(r.data)) {
+		r.fail("count %d larger than the rest of the file", n)
+		return 0
+	}
+	return int(n)
+}
+
+func (r *compiledReader) bytes(n int) []byte {
+	if n > len(r.data) {
+		r.fail("truncated")
+		return nil
+	}
+	b := r.data[:n]
+	r.data = r.data[n:]
+	return b
+}
+
+func (r *compiledReader) string() string {
+	n := r.uint()
+	if n >= uint64(len(r.table)) {
+		r.fail("string %d out of range", n)
+		return ""
+	}
+	return r.table[n]
+}
+
+func (r *compiledReader) hash(size int) string {
+	switch kind := r.uint(); kind {
+	case hashNone:
+		return ""
+	case hashBytes:
+		return hex.EncodeToString(r.bytes(size))
+	case hashString:
+		return r.string()
+	default:
+		r.fail("bad hash kind %d", kind)
+	}
+	return ""
+}
+
+func (r *compiledReader) game() *Game {
+	g := new(Game)
+	for _, s := range []*string{&g.Name, &g.CloneOf, &g.ROMOf, &g.SampleOf, &g.IsDevice, &g.IsBIOS, &g.Runnable, &g.folder} {
+		*s = r.string()
+	}
+	g.ROMs = make([]ROM, r.count())
+	for i := range g.ROMs {
+		rom := &g.ROMs[i]
+		rom.Name = r.string()
+		rom.Size = r.uint()
+		rom.CRC32 = r.hash(4)
+		rom.SHA1 = r.hash(20)
+		for _, s := range []*string{&rom.Status, &rom.Merge, &rom.Optional, &rom.BIOS} {
+			*s = r.string()
+		}
+	}
+	g.CHDs = make([]CHD, r.count())
+	for i := range g.CHDs {
+		c := &g.CHDs[i]
+		c.Name = r.string()
+		c.SHA1 = r.hash(20)
+		c.MD5 = r.hash(16)
+		for _, s := range []*string{&c.Status, &c.Merge, &c.Optional} {
+			*s = r.string()
+		}
+	}
+	g.BIOSSets = make([]BIOSSet, r.count())
+	for i := range g.BIOSSets {
+		b := &g.BIOSSets[i]
+		b.Name = r.string()
+		b.Description = r.string()
+		b.Default = r.string()
+	}
+	g.DeviceRefs = make([]DeviceRef, r.count())
+	for i := range g.DeviceRefs {
+		g.DeviceRefs[i].Name = r.string()
+	}
+	g.Samples = make([]Sample, r.count())
+	for i := range g.Samples {
+		g.Samples[i].Name = r.string()
+	}
+	return g
+}
+
+func (r *compiledReader) catalog(c *Catalog) {
+	c.Dir = r.string()
+	n := r.count()
+	for i := 0; i < n && r.err == nil; i++ {
+		g := r.game()
+		c.Games[g.Name] = g
+	}
+}
+
+// fill in machines and softwareLists from the compiled form of filename
+// returns false if there isn't an up-to-date one, in which case nothing is changed
+func readCompiled(filename string, fi os.FileInfo, fstree *fuse.Tree) (bool, error) {
+	data, err := os.ReadFile(compiledFilename(filename))
+	if os.IsNotExist(err) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	r := &compiledReader{
+		data:	data,
+	}
+	if r.uint() != compiledMagic || r.uint() != compiledVersion {
+		return false, nil		// from another version; just make it again
+	}
+	if string(r.bytes(r.count())) != compiledSource(filename) {
+		return false, nil		// made from another catalog
+	}
+	if r.uint() != uint64(fi.Size()) || r.uint() != uint64(fi.ModTime().UnixNano()) {
+		return false, nil
+	}
+	r.table = make([]string, r.count())
+	for i := range r.table {
+		r.table[i] = string(r.bytes(r.count()))
+	}
+
+	var cats []*Catalog
+	n := r.count()
+	for i := 0; i < n && r.err == nil; i++ {
+		c := &Catalog{
+			Games:	map[string]*Game{},
+		}
+		r.catalog(c)
+		cats = append(cats, c)
+	}
+	if r.err == nil && len(r.data) != 0 {
+		r.fail("junk at end")
+	}
+	if r.err == nil && (len(cats) == 0 || cats[0].Dir != "") {
+		r.fail("no main catalog")
+	}
+	if r.err != nil {
+		return false, r.err
+	}
+
+	for name, g := range cats[0].Games {
+		games[name] = g
+	}
+	for _, c := range cats[1:] {
+		softwareLists[c.Dir] = c
+		c.prepare(fstree)
+	}
+	return true, nil
+}
+
+// the compile command: compile mamexml
+func compile(args []string) {
+	if len(args) != 1 {
+		usage()
+	}
+	fi := statCatalog(args[0])
+	readCatalogFile(args[0], new(fuse.Tree))
+	err := writeCompiled(args[0], fi)
+	if err != nil {
+		log.Fatalf("could not compile %s: %v", args[0], err)
+	}
+}
+
+func statCatalog(filename string) os.FileInfo {
+	fi, err := os.Stat(filename)
+	if err != nil {
+		log.Fatalf("could not open MAME XML file %s: %v", filename, err)
+	}
+	return fi
+}
+
+// use the compiled form of filename if it's up to date; if it's out of date (or -catalogindex says where it should be and it isn't there yet), make it again
+func loadCatalog(filename string, fstree *fuse.Tree) {
+	fi := statCatalog(filename)
+	ok, err := readCompiled(filename, fi, fstree)
+	if err != nil {
+		log.Printf("could not read compiled catalog %s; reading %s instead: %v\n", compiledFilename(filename), filename, err)
+	}
+	if ok {
+		log.Printf("loaded compiled catalog %s\n", compiledFilename(filename))
+		return
+	}
+	readCatalogFile(filename, fstree)
+	if _, err := os.Stat(compiledFilename(filename)); err == nil || catalogIndex != "" {		// only if asked for, since it might go somewhere unexpected (like next to the MAME executable)
+		err = writeCompiled(filename, fi)
+		if err != nil {
+			log.Printf("could not compile %s: %v\n", filename, err)
+		}
+	}
+}
@@ -0,0 +1,187 @@
+// 18 october 2026
+package main
+
+import (
+	"testing"
+	"os"
+	"time"
+	"reflect"
+	"path/filepath"
+	"code.google.com/p/rsc/fuse"
+)
+
+// just what came from the XML, without what Catalog.prepare() adds (which doesn't happen in the same order twice)
+func xmlOnly(g *Game) *Game {
+	h := &Game{
+		Name:		g.Name,
+		CloneOf:		g.CloneOf,
+		ROMOf:		g.ROMOf,
+		SampleOf:		g.SampleOf,
+		IsDevice:		g.IsDevice,
+		IsBIOS:		g.IsBIOS,
+		Runnable:		g.Runnable,
+		folder:		g.folder,
+	}
+	if len(g.ROMs) != 0 {		// the compiled form always makes these, XML only when there are any
+		h.ROMs = g.ROMs
+	}
+	if len(g.CHDs) != 0 {
+		h.CHDs = g.CHDs
+	}
+	if len(g.BIOSSets) != 0 {
+		h.BIOSSets = g.BIOSSets
+	}
+	if len(g.DeviceRefs) != 0 {
+		h.DeviceRefs = g.DeviceRefs
+	}
+	if len(g.Samples) != 0 {
+		h.Samples = g.Samples
+	}
+	return h
+}
+
+// compile a catalog with a software list into a temporary file, and point readCompiled() there too
+func compileTestCatalog(t *testing.T, filename string) (map[string]*Game, map[string]*Catalog) {
+	catalogIndex = filepath.Join(t.TempDir(), "test.mfidx")
+	t.Cleanup(func() {
+		catalogIndex = ""
+	})
+	g := loadTestCatalog(t, filename)
+	getSoftwareList("testdata/softlist/megacd.xml", new(fuse.Tree))
+	lists := softwareLists
+	err := writeCompiled(filename, statCatalog(filename))
+	if err != nil {
+		t.Fatalf("could not compile: %v", err)
+	}
+	return g, lists
+}
+
+func readTestCompiled(t *testing.T, filename string, fi os.FileInfo) bool {
+	machines = &Catalog{
+		Games:	map[string]*Game{},
+	}
+	games = machines.Games
+	softwareLists = map[string]*Catalog{}
+	ok, err := readCompiled(filename, fi, new(fuse.Tree))
+	if err != nil {
+		t.Fatalf("could not read compiled catalog: %v", err)
+	}
+	return ok
+}
+
+func compareGames(t *testing.T, what string, got map[string]*Game, want map[string]*Game) {
+	if len(got) != len(want) {
+		t.Errorf("%s: %d sets, want %d", what, len(got), len(want))
+	}
+	for name, w := range want {
+		g, ok := got[name]
+		if !ok {
+			t.Errorf("%s: %s missing", what, name)
+			continue
+		}
+		if a, b := xmlOnly(g), xmlOnly(w); !reflect.DeepEqual(a, b) {
+			t.Errorf("%s: %s is\n%+v\nwant\n%+v", what, name, a, b)
+		}
+	}
+}
+
+func TestCompiledRoundTrip(t *testing.T) {
+	const filename = "testdata/listxml/mame0084.xml"
+	want, wantLists := compileTestCatalog(t, filename)
+	if !readTestCompiled(t, filename, statCatalog(filename)) {
+		t.Fatal("freshly compiled catalog not used")
+	}
+	compareGames(t, "main catalog", games, want)
+	if len(softwareLists) != len(wantLists) {
+		t.Errorf("%d software lists, want %d", len(softwareLists), len(wantLists))
+	}
+	for name, w := range wantLists {
+		c, ok := softwareLists[name]
+		if !ok {
+			t.Errorf("software list %s missing", name)
+			continue
+		}
+		if c.Dir != w.Dir {
+			t.Errorf("software list %s has dir %q, want %q", name, c.Dir, w.Dir)
+		}
+		compareGames(t, "software list " + name, c.Games, w.Games)
+	}
+
+	// the things most likely to go wrong, in case the comparison above is ever loosened
+	if testGame(t, games, "neogeo").Runnable != "no" {
+		t.Errorf("runnable=\"no\" lost")
+	}
+	if area51 := testGame(t, games, "area51").CHDs; len(area51) != 1 || area51[0].MD5 != "a8cc9c7c5e8d9d5f6b6ab5d1e56b5c8a" || area51[0].SHA1 != "" {
+		t.Errorf("MD5-only disk is %+v", area51)
+	}
+	megacd := softwareLists["megacd"].Games
+	if sonicj := testGame(t, megacd, "sonicj"); len(sonicj.Parents) != 1 || sonicj.CHDs[0].MD5 != "0123456789ABCDEF0123456789ABCDEF" {
+		t.Errorf("wrong sonicj %+v", sonicj)
+	}
+	if rom := testGame(t, megacd, "bios").ROMs[0]; rom.CRC32 != "DEADBEEF" || rom.SHA1 != "not a sha1" {
+		t.Errorf("uppercase and invalid hashes came back as %q and %q", rom.CRC32, rom.SHA1)
+	}
+}
+
+func TestCompiledStale(t *testing.T) {
+	src := filepath.Join(t.TempDir(), "mame.xml")
+	data, err := os.ReadFile("testdata/listxml/mame0084.xml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.WriteFile(src, data, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	compileTestCatalog(t, src)
+	fi := statCatalog(src)
+	if !readTestCompiled(t, src, fi) {
+		t.Fatal("freshly compiled catalog not used")
+	}
+
+	// the same catalog somewhere else, with the same size and modification time, as if -catalogindex were shared
+	other := filepath.Join(t.TempDir(), "mame.xml")
+	err = os.WriteFile(other, data, 0644)
+	if err == nil {
+		err = os.Chtimes(other, fi.ModTime(), fi.ModTime())
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	if readTestCompiled(t, other, statCatalog(other)) {
+		t.Errorf("compiled catalog used for a different file")
+	}
+
+	later := fi.ModTime().Add(time.Second)
+	err = os.Chtimes(src, later, later)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if readTestCompiled(t, src, statCatalog(src)) {
+		t.Errorf("compiled catalog used after the modification time changed")
+	}
+
+	err = os.WriteFile(src, append(data, '\n'), 0644)
+	if err == nil {
+		err = os.Chtimes(src, fi.ModTime(), fi.ModTime())
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	if readTestCompiled(t, src, statCatalog(src)) {
+		t.Errorf("compiled catalog used after the size changed")
+	}
+}
+
+// and the folders of Logiqx <dir>s
+func TestCompiledFolders(t *testing.T) {
+	const filename = "testdata/logiqx/fbneo.dat"
+	want, _ := compileTestCatalog(t, filename)
+	if !readTestCompiled(t, filename, statCatalog(filename)) {
+		t.Fatal("freshly compiled catalog not used")
+	}
+	compareGames(t, "Logiqx catalog", games, want)
+	if folder := testGame(t, games, "sf2ua").folder; folder != filepath.Join("capcom", "cps1 clones") {
+		t.Errorf("sf2ua's folder came back as %q", folder)
+	}
+}
@@ -0,0 +1,153 @@
+// 18 october 2026
+package main
+
+import (
+	"fmt"
+	"os"
+	"io"
+	"os/exec"
+	"bytes"
+	"compress/gzip"
+	"archive/zip"
+	"strings"
+)
+
+// catalogs are big (-listxml is over 200MB these days) and compress well, so they can be stored gzipped, xz'd, zstd'd, or alone in a zip
+// which one is worked out from the first few bytes, not the extension
+// there's no xz or zstd in the standard library, so like with 7z we run the real tools
+var xzPath = "xz"
+var zstdPath = "zstd"
+
+var (
+	gzipMagic	= []byte{0x1F, 0x8B}
+	zipMagic	= []byte("PK\x03\x04")
+	xzMagic	= []byte{0xFD, '7', 'z', 'X', 'Z', 0x00}
+	zstdMagic	= []byte{0x28, 0xB5, 0x2F, 0xFD}
+)
+
+// returns nil and no error if start isn't the start of anything compressed
+func openCompressed(filename string, start []byte) (io.ReadCloser, error) {
+	switch {
+	case bytes.HasPrefix(start, gzipMagic):
+		return openGzip(filename)
+	case bytes.HasPrefix(start, zipMagic):
+		return openSingleFileZip(filename)
+	case bytes.HasPrefix(start, xzMagic):
+		return runCommand(exec.Command(xzPath, "-dc", "--", filename))
+	case bytes.HasPrefix(start, zstdMagic):
+		return runCommand(exec.Command(zstdPath, "-dc", "--", filename))
+	}
+	return nil, nil
+}
+
+// closes both the decompressor and the file under it
+type decompressor struct {
+	io.ReadCloser
+	f	io.Closer
+}
+
+func (d *decompressor) Close() error {
+	err := d.ReadCloser.Close()
+	ferr := d.f.Close()
+	if err != nil {
+		return err
+	}
+	return ferr
+}
+
+func openGzip(filename string) (io.ReadCloser, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	r, err := gzip.NewReader(f)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &decompressor{r, f}, nil
+}
+
+func openSingleFileZip(filename string) (io.ReadCloser, error) {
+	z, err := zip.OpenReader(filename)
+	if err != nil {
+		return nil, err
+	}
+	var files []*zip.File
+	for _, f := range z.File {
+		if !strings.HasSuffix(f.Name, "/") {
+			files = append(files, f)
+		}
+	}
+	if len(files) != 1 {
+		z.Close()
+		return nil, fmt.Errorf("zip has %d files in it; expected just the one", len(files))
+	}
+	r, err := files[0].Open()
+	if err != nil {
+		z.Close()
+		return nil, err
+	}
+	return &decompressor{r, z}, nil
+}
+
+// the output of a command we've started, like MAME -listxml, xz -dc, or 7z e
+type commandReader struct {
+	io.ReadCloser
+	cmd	*exec.Cmd
+	done	bool
+	err	error
+}
+
+// anything it says goes to our stderr unless cmd says otherwise
+func runCommand(cmd *exec.Cmd) (io.ReadCloser, error) {
+	if cmd.Stderr == nil {
+		cmd.Stderr = os.Stderr
+	}
+	out, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	err = cmd.Start()
+	if err != nil {
+		return nil, err
+	}
+	return &commandReader{
+		ReadCloser:	out,
+		cmd:			cmd,
+	}, nil
+}
+
+// if the command fails, say so instead of just running out of output
+func (c *commandReader) Read(p []byte) (int, error) {
+	if c.done {		// the pipe is closed now
+		if c.err != nil {
+			return 0, c.err
+		}
+		return 0, io.EOF
+	}
+	n, err := c.ReadCloser.Read(p)
+	if err != nil && c.wait() != nil {
+		err = c.err
+	}
+	return n, err
+}
+
+func (c *commandReader) wait() error {
+	if !c.done {
+		c.done = true
+		c.err = c.cmd.Wait()
+		if c.err != nil {
+			c.err = fmt.Errorf("%s failed: %v", strings.Join(c.cmd.Args, " "), c.err)
+		}
+	}
+	return c.err
+}
+
+// we stop reading at the end of the root element, so drain whatever's left before waiting
+func (c *commandReader) Close() error {
+	if !c.done {
+		io.Copy(io.Discard, c.ReadCloser)
+	}
+	return c.wait()
+}
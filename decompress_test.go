@@ -0,0 +1,59 @@
+// 18 october 2026
+package main
+
+import (
+	"testing"
+	"os"
+	"compress/gzip"
+	"archive/zip"
+	"path/filepath"
+)
+
+func compressTestCatalog(t *testing.T, kind string) string {
+	xml, err := os.ReadFile("testdata/listxml/mame0250.xml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	filename := filepath.Join(t.TempDir(), "mame.dat")		// not .gz or .zip; it goes by what's in the file
+	f, err := os.Create(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	switch kind {
+	case "gzip":
+		w := gzip.NewWriter(f)
+		w.Write(xml)
+		err = w.Close()
+	case "zip", "zip with two files":
+		w := zip.NewWriter(f)
+		w.Create("dir/")		// directories don't count
+		fw, _ := w.Create("mame0250.xml")
+		fw.Write(xml)
+		if kind == "zip with two files" {
+			fw, _ = w.Create("readme.txt")
+			fw.Write([]byte("hi"))
+		}
+		err = w.Close()
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return filename
+}
+
+func TestCompressedCatalog(t *testing.T) {
+	for _, kind := range []string{"gzip", "zip"} {
+		g := loadTestCatalog(t, compressTestCatalog(t, kind))
+		if len(g) != 2 || g["naomi"] == nil || g["ikaruga"] == nil {
+			t.Errorf("%s: loaded %d sets, want naomi and ikaruga", kind, len(g))
+		}
+	}
+}
+
+func TestCompressedCatalogZipTwoFiles(t *testing.T) {
+	if r, err := openListXML(compressTestCatalog(t, "zip with two files")); err == nil {
+		r.Close()
+		t.Errorf("zip with two files in it accepted")
+	}
+}
@@ -9,8 +9,17 @@ import (
 )
 
 var dirs []string
+var sampleDirs []string
 
 func getDirList(listfile string) {
+	dirs = readDirList(listfile)
+}
+
+func getSampleDirList(listfile string) {
+	sampleDirs = readDirList(listfile)
+}
+
+func readDirList(listfile string) (list []string) {
 	_f, err := os.Open(listfile)
 	if err != nil {
 		log.Fatalf("could not open directory list file %s: %v", listfile, err)
@@ -25,6 +34,7 @@ func getDirList(listfile string) {
 		} else if err != nil {
 			log.Fatalf("could not read directory list file %s: %v", listfile, err)
 		}
-		dirs = append(dirs, dir[:len(dir) - 1])		// drop newline
+		list = append(list, dir[:len(dir) - 1])		// drop newline
 	}
+	return list
 }
@@ -0,0 +1,290 @@
+// 18 october 2026
+package main
+
+import (
+	"fmt"
+	"math/bits"
+)
+
+// CHD's flac and cdfl codecs store 16-bit stereo audio as bare FLAC frames, without the fLaC marker or any metadata blocks
+// everything the decoder needs is in the frame headers anyway, so this only knows how to decode frames
+// TODO only what CHD writes is supported: two channels of 16-bit samples
+
+// reads a unary-coded number (zeroes ended by a one)
+func (b *bitReader) unary() (uint32, error) {
+	var q uint32
+	for {
+		if b.overflow() {
+			return 0, fmt.Errorf("FLAC data truncated")
+		}
+		v := b.peek(32)
+		if v != 0 {
+			z := bits.LeadingZeros32(v)
+			b.pos += z + 1
+			return q + uint32(z), nil
+		}
+		q += 32
+		b.pos += 32
+	}
+}
+
+func (b *bitReader) signed(n int) int32 {
+	if n == 0 {
+		return 0
+	}
+	v := b.read(n)
+	return int32(v << uint(32 - n)) >> uint(32 - n)
+}
+
+var flacBlockSizes = [16]int{
+	0, 192, 576, 1152, 2304, 4608, -1, -2,
+	256, 512, 1024, 2048, 4096, 8192, 16384, 32768,
+}
+
+var flacSampleSizes = [8]int{
+	16, 8, 12, 0, 16, 20, 24, 32,		// 0 means "whatever STREAMINFO says", which for CHD is always 16
+}
+
+var flacFixedCoeffs = [5][]int32{
+	{},
+	{1},
+	{2, -1},
+	{3, -3, 1},
+	{4, -6, 4, -1},
+}
+
+func flacCRC16(data []byte) uint16 {
+	var crc uint16
+	for _, c := range data {
+		crc ^= uint16(c) << 8
+		for i := 0; i < 8; i++ {
+			if crc & 0x8000 != 0 {
+				crc = crc << 1 ^ 0x8005
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return crc
+}
+
+// decode frames from data until out is full of interleaved samples; returns how much of data was used
+func flacDecode(data []byte, out []byte, bigEndian bool) (int, error) {
+	b := &bitReader{
+		data:	data,
+	}
+	var ch [2][]int32
+	n := 0
+	for n < len(out) {
+		start := b.bytePos()
+		b.pos = start * 8
+		if b.read(14) != 0x3FFE {
+			return 0, fmt.Errorf("FLAC frame sync not found at %d", start)
+		}
+		b.read(2)		// reserved and blocking strategy
+		bscode := b.read(4)
+		srcode := b.read(4)
+		chans := b.read(4)
+		sscode := b.read(3)
+		b.read(1)
+		if chans != 1 && (chans < 8 || chans > 10) {
+			return 0, fmt.Errorf("unsupported FLAC channel assignment %d", chans)
+		}
+		if flacSampleSizes[sscode] != 16 {
+			return 0, fmt.Errorf("unsupported FLAC sample size code %d", sscode)
+		}
+		// frame or sample number, UTF-8 coded
+		first := b.read(8)
+		for extra := bits.LeadingZeros8(^uint8(first)) - 1; extra > 0; extra-- {
+			b.read(8)
+		}
+		blocksize := flacBlockSizes[bscode]
+		switch blocksize {
+		case 0:
+			return 0, fmt.Errorf("reserved FLAC block size")
+		case -1:
+			blocksize = int(b.read(8)) + 1
+		case -2:
+			blocksize = int(b.read(16)) + 1
+		}
+		switch srcode {
+		case 12:
+			b.read(8)
+		case 13, 14:
+			b.read(16)
+		}
+		b.read(8)		// header CRC-8; the CRC-16 at the end covers the header too
+
+		for c := 0; c < 2; c++ {
+			bps := 16
+			if (chans == 8 && c == 1) || (chans == 9 && c == 0) || (chans == 10 && c == 1) {
+				bps++		// side channels need an extra bit
+			}
+			if cap(ch[c]) < blocksize {
+				ch[c] = make([]int32, blocksize)
+			}
+			ch[c] = ch[c][:blocksize]
+			err := flacSubframe(b, ch[c], bps)
+			if err != nil {
+				return 0, err
+			}
+		}
+
+		b.pos = b.bytePos() * 8
+		end := b.bytePos()
+		crc := b.read(16)
+		if b.overflow() {
+			return 0, fmt.Errorf("FLAC data truncated")
+		}
+		if uint16(crc) != flacCRC16(data[start:end]) {
+			return 0, fmt.Errorf("FLAC frame at %d has bad CRC", start)
+		}
+
+		left, right := ch[0], ch[1]
+		for i := 0; i < blocksize && n < len(out); i++ {
+			l, r := left[i], right[i]
+			switch chans {
+			case 8:		// left/side
+				r = l - r
+			case 9:		// side/right
+				l = l + r
+			case 10:		// mid/side
+				mid := l << 1 | r & 1
+				l = (mid + r) >> 1
+				r = (mid - r) >> 1
+			}
+			for _, s := range [2]int32{l, r} {
+				if n + 2 > len(out) {
+					return 0, fmt.Errorf("FLAC data doesn't fit evenly")
+				}
+				if bigEndian {
+					out[n], out[n + 1] = byte(s >> 8), byte(s)
+				} else {
+					out[n], out[n + 1] = byte(s), byte(s >> 8)
+				}
+				n += 2
+			}
+		}
+	}
+	return b.bytePos(), nil
+}
+
+func flacSubframe(b *bitReader, s []int32, bps int) error {
+	if b.read(1) != 0 {
+		return fmt.Errorf("bad FLAC subframe header")
+	}
+	typ := b.read(6)
+	wasted := 0
+	if b.read(1) != 0 {
+		k, err := b.unary()
+		if err != nil {
+			return err
+		}
+		wasted = int(k) + 1
+		bps -= wasted
+	}
+
+	switch {
+	case typ == 0:		// constant
+		v := b.signed(bps)
+		for i := range s {
+			s[i] = v
+		}
+	case typ == 1:		// verbatim
+		for i := range s {
+			s[i] = b.signed(bps)
+		}
+	case typ >= 8 && typ <= 12:		// fixed
+		order := int(typ & 7)
+		for i := 0; i < order; i++ {
+			s[i] = b.signed(bps)
+		}
+		err := flacResidual(b, s, order)
+		if err != nil {
+			return err
+		}
+		flacPredict(s, order, flacFixedCoeffs[order], 0)
+	case typ >= 32:		// LPC
+		order := int(typ & 31) + 1
+		for i := 0; i < order; i++ {
+			s[i] = b.signed(bps)
+		}
+		precision := int(b.read(4)) + 1
+		if precision == 16 {
+			return fmt.Errorf("bad FLAC LPC precision")
+		}
+		shift := b.signed(5)
+		if shift < 0 {
+			return fmt.Errorf("negative FLAC LPC shift")
+		}
+		coeffs := make([]int32, order)
+		for i := range coeffs {
+			coeffs[i] = b.signed(precision)
+		}
+		err := flacResidual(b, s, order)
+		if err != nil {
+			return err
+		}
+		flacPredict(s, order, coeffs, uint(shift))
+	default:
+		return fmt.Errorf("reserved FLAC subframe type %d", typ)
+	}
+
+	if wasted != 0 {
+		for i := range s {
+			s[i] <<= uint(wasted)
+		}
+	}
+	if b.overflow() {
+		return fmt.Errorf("FLAC data truncated")
+	}
+	return nil
+}
+
+// fills in s[order:] with the residuals
+func flacResidual(b *bitReader, s []int32, order int) error {
+	method := b.read(2)
+	if method > 1 {
+		return fmt.Errorf("reserved FLAC residual coding method %d", method)
+	}
+	parambits, escape := 4, uint32(15)
+	if method == 1 {
+		parambits, escape = 5, 31
+	}
+	porder := uint(b.read(4))
+	psize := len(s) >> porder
+	if psize << porder != len(s) || psize < order {
+		return fmt.Errorf("bad FLAC partition order %d", porder)
+	}
+	i := order
+	for p := 0; p < 1 << porder; p++ {
+		end := (p + 1) * psize
+		param := b.read(parambits)
+		if param == escape {
+			n := int(b.read(5))
+			for ; i < end; i++ {
+				s[i] = b.signed(n)
+			}
+			continue
+		}
+		for ; i < end; i++ {
+			q, err := b.unary()
+			if err != nil {
+				return err
+			}
+			v := q << param | b.read(int(param))
+			s[i] = int32(v >> 1) ^ -int32(v & 1)
+		}
+	}
+	return nil
+}
+
+func flacPredict(s []int32, order int, coeffs []int32, shift uint) {
+	for i := order; i < len(s); i++ {
+		var sum int64
+		for j, c := range coeffs {
+			sum += int64(c) * int64(s[i - 1 - j])
+		}
+		s[i] += int32(sum >> shift)
+	}
+}
@@ -3,9 +3,12 @@ package main
 
 import (
 	"os"
+	"sync"
 	"code.google.com/p/rsc/fuse"
 	"path/filepath"
 	"io"
+	"strings"
+	"log"
 )
 
 // TODO:
@@ -13,23 +16,138 @@ import (
 // - figure out why it takes 15 seconds to ls the ROMs folder (56 seconds for ls -l)
 
 func (g *Game) AddToTree(t *fuse.Tree) {
-	t.Add(g.Name + ".zip", NewROMFile(g))
+	dir := g.cat.Dir		// for software lists; Logiqx <dir>s aren't, since MAME wants every set right in the rompath
+	for _, ext := range g.archiveNodeExts() {
+		t.Add(filepath.Join(dir, g.Name + ext), NewROMFile(g, ext))
+		if g.IsBIOS == yes {		// so they're easy to find
+			t.Add(filepath.Join("bios", g.Name + ext), NewROMFile(g, ext))
+		}
+	}
+	if g.looseOnDisk() {
+		for _, r := range g.ROMs {
+			if r.Status != nodump {
+				name := strings.TrimSpace(r.Name)
+				t.Add(filepath.Join(dir, g.Name, name), NewLooseROMFile(g, name))
+			}
+		}
+	}
 	for _, c := range g.CHDs {
-		t.Add(filepath.Join(g.Name, c.Name + ".chd"), NewCHDFile(g, c.Name))
+		t.Add(filepath.Join(dir, g.Name, c.Name + ".chd"), NewCHDFile(g, c.Name))
+		t.Add(filepath.Join(dir, g.Name, c.Name + ".chd.info"), NewCHDInfoFile(g, c.Name))
+		if *rawView {		// we don't know what kind of disk it is yet, so add all of them like with archives
+			info := new(rawCHDInfo)
+			for _, ext := range rawImageExts {
+				t.Add(filepath.Join("raw", dir, g.Name, c.Name + ext), NewRawCHDFile(g, c.Name, ext, info))
+			}
+		}
 	}
 }
 
-// generic file node and handle; embedded by ROMNode and CHDNode to get the job done
-type FUSEFile struct {
-	size		uint64
-	f		*os.File
+// the names in each rompath (or software list directory in one), normalized, read once while the tree is built
+// each is true if it's a directory (so possibly a loose set) and false if not
+var archivesOnDisk = map[string]map[string]bool{}
+
+func archivesIn(dir string) map[string]bool {
+	names, ok := archivesOnDisk[dir]
+	if !ok {
+		names = map[string]bool{}
+		d, err := os.Open(dir)
+		if err == nil {
+			entries, _ := d.ReadDir(-1)
+			d.Close()
+			for _, e := range entries {
+				isDir := e.IsDir()
+				if e.Type() & os.ModeSymlink != 0 {		// a set kept somewhere else
+					fi, err := os.Stat(filepath.Join(dir, e.Name()))
+					isDir = err == nil && fi.IsDir()
+				}
+				names[normalizeName(e.Name())] = isDir
+			}
+		}
+		archivesOnDisk[dir] = names
+	}
+	return names
+}
+
+// the kinds of archive to show a set as: whichever are actually there, or just .zip if none are (that's what a synthesized set is served as, and what MAME looks for first)
+// otherwise every set would have a .7z next to its .zip that can never be opened
+// if the set might end up synthesized, it needs the .zip even if it's only on disk as something else
+// loose sets get a directory of their own instead
+// TODO the tree doesn't change after mounting, so an archive of another kind added later won't show up
+func (g *Game) archiveNodeExts() []string {
+	var exts []string
+	for _, ext := range archiveExts {
+		if ext == "" {
+			continue
+		}
+		if ext == ".zip" && canSynthesize() {
+			exts = append(exts, ext)
+			continue
+		}
+		for _, rompath := range dirs {
+			if _, ok := archivesIn(filepath.Join(rompath, g.diskDir()))[normalizeName(g.Name + ext)]; ok {
+				exts = append(exts, ext)
+				break
+			}
+		}
+	}
+	if len(exts) == 0 {
+		exts = []string{".zip"}
+	}
+	return exts
+}
+
+// whether there's a directory named after the set in any rompath; only then does it get loose ROM nodes, or else every set would have a directory of files that are never there
+func (g *Game) looseOnDisk() bool {
+	for _, rompath := range dirs {
+		if archivesIn(filepath.Join(rompath, g.diskDir()))[normalizeName(g.Name)] {
+			return true
+		}
+	}
+	return false
 }
 
-func (f *FUSEFile) Attr() fuse.Attr {
+// every node works out its size in Attr, since stat is how most programs find out how big a file is and they do it before opening
+// for most nodes that means finding the set, which is only done once
+func sizedAttr(size uint64) fuse.Attr {
 	return fuse.Attr{
 		Mode:	0444,
-		Size:		f.size,
+		Size:		size,
+	}
+}
+
+// a node's size, remembered once it's known since nothing changes while we're mounted and ls -l stats everything
+type nodeSize struct {
+	lock		sync.Mutex
+	known	bool
+	size		uint64
+}
+
+// f gives the size, and false if there isn't one yet (the set wasn't found, say); it'll be asked again next time
+func (s *nodeSize) attr(f func() (uint64, bool)) fuse.Attr {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if !s.known {
+		s.size, s.known = f()
 	}
+	return sizedAttr(s.size)
+}
+
+// false if the file isn't there
+func fileSize(filename string) (uint64, bool) {
+	if filename == "" {
+		return 0, false
+	}
+	fi, err := os.Stat(filename)
+	if err != nil {
+		return 0, false
+	}
+	return uint64(fi.Size()), true		// int64 -> uint64 should be safe
+}
+
+// generic file handle; embedded by ROMNode and CHDNode to get the job done
+type FUSEFile struct {
+	f		*os.File
 }
 
 func (f *FUSEFile) open(filename string) fuse.Error {
@@ -39,14 +157,6 @@ func (f *FUSEFile) open(filename string) fuse.Error {
 	if err != nil {
 		return fuse.EIO
 	}
-	if f.size == 0 {			// try to get size
-		s, err := f.f.Stat()
-		if err != nil {		// if we failed, we have an issue
-			f.f.Close()		// TODO let it slide?
-			return fuse.EIO
-		}
-		f.size = uint64(s.Size())		// int64 -> uint64 should be safe
-	}
 	return nil
 }
 
@@ -71,28 +181,237 @@ func (f *FUSEFile) Release(*fuse.ReleaseRequest, fuse.Intr) fuse.Error {
 
 type ROMFile struct {
 	g		*Game
+	ext		string		// only serve the set if it was found in this kind of archive
+	size		nodeSize
 	*FUSEFile
 }
 
 // because the *FUSEFile embed won't allocate itself
-func NewROMFile(g *Game) *ROMFile {
+func NewROMFile(g *Game, ext string) *ROMFile {
 	return &ROMFile{
 		g:		g,
+		ext:		ext,
 		FUSEFile:	new(FUSEFile),
 	}
 }
 
+// what the node stands for: the synthesized zip if synth is true, or else the archive filename; ok is false if there's nothing
+func (r *ROMFile) source() (synth bool, filename string, ok bool) {
+	found, err := r.g.Find()
+	if !found || err != nil {		// TODO report error somehow
+		return false, "", false
+	}
+	if r.g.Synth != nil {
+		return true, "", r.ext == ".zip"
+	}
+	return false, r.g.ROMLoc, strings.EqualFold(filepath.Ext(r.g.ROMLoc), r.ext)		// MAME will try the other extensions on its own
+}
+
+func (r *ROMFile) Attr() fuse.Attr {
+	return r.size.attr(func() (uint64, bool) {
+		synth, filename, ok := r.source()
+		switch {
+		case !ok:
+			return 0, false
+		case synth:
+			return uint64(r.g.Synth.size), true
+		}
+		return fileSize(filename)
+	})
+}
+
 // TODO DRY?
 func (r *ROMFile) Open(req *fuse.OpenRequest, resp *fuse.OpenResponse, intr fuse.Intr) (h fuse.Handle, ferr fuse.Error) {
 	// TODO uint32 conversion here safe? should I just use the syscall ones instead? FUSE documentation says the values should match...
 	if (req.Flags & uint32(os.O_WRONLY | os.O_RDWR)) != 0 {		// ban writes
 		return nil, fuse.EPERM
 	}
+	synth, filename, ok := r.source()
+	if !ok {
+		return nil, fuse.ENOENT
+	}
+	if synth {
+		return &SynthHandle{
+			r:	r.g.Synth.NewReader(),
+		}, nil
+	}
+	ferr = r.open(filename)
+	if ferr != nil {
+		return nil, ferr
+	}
+	return r, nil
+}
+
+// each open of a synthesized zip gets its own handle so concurrent readers don't fight over where they are
+type SynthHandle struct {
+	r		*synthReader
+}
+
+func (h *SynthHandle) Read(req *fuse.ReadRequest, resp *fuse.ReadResponse, intr fuse.Intr) fuse.Error {
+	resp.Data = make([]byte, req.Size)
+	n, err := h.r.ReadAt(resp.Data, req.Offset)
+	if err == io.EOF {
+		resp.Data = resp.Data[:n]
+	} else if err != nil {
+		log.Printf("error reading synthesized zip: %v\n", err)
+		return fuse.EIO
+	}
+	return nil
+}
+
+func (h *SynthHandle) Release(*fuse.ReleaseRequest, fuse.Intr) fuse.Error {
+	h.r.Close()
+	return nil
+}
+
+type LooseROMFile struct {
+	g		*Game
+	name	string
+	size		nodeSize
+	*FUSEFile
+}
+
+// because the *FUSEFile embed won't allocate itself
+func NewLooseROMFile(g *Game, name string) *LooseROMFile {
+	return &LooseROMFile{
+		g:		g,
+		name:	name,
+		FUSEFile:	new(FUSEFile),
+	}
+}
+
+// the file the node stands for, or "" if there isn't one
+func (r *LooseROMFile) source() string {
 	found, err := r.g.Find()
-	if !found || err != nil {		// TODO report error somehow
+	if !found || err != nil {
+		return ""
+	}
+	if r.g.ROMLoc == "" || !isLooseSet(r.g.ROMLoc) {		// not a loose set; MAME will use the archive instead
+		return ""
+	}
+	filename := normalizedPath(r.g.ROMLoc, r.name)
+	if _, err := os.Stat(filename); os.IsNotExist(err) {		// belongs to a parent
+		return ""
+	}
+	return filename
+}
+
+func (r *LooseROMFile) Attr() fuse.Attr {
+	return r.size.attr(func() (uint64, bool) {
+		return fileSize(r.source())
+	})
+}
+
+// TODO DRY?
+func (r *LooseROMFile) Open(req *fuse.OpenRequest, resp *fuse.OpenResponse, intr fuse.Intr) (h fuse.Handle, ferr fuse.Error) {
+	if (req.Flags & uint32(os.O_WRONLY | os.O_RDWR)) != 0 {		// ban writes
+		return nil, fuse.EPERM
+	}
+	filename := r.source()
+	if filename == "" {
+		return nil, fuse.ENOENT
+	}
+	ferr = r.open(filename)
+	if ferr != nil {
+		return nil, ferr
+	}
+	return r, nil
+}
+
+type SampleFile struct {
+	s		*SampleSet
+	ext		string
+	size		nodeSize
+	*FUSEFile
+}
+
+// because the *FUSEFile embed won't allocate itself
+func NewSampleFile(s *SampleSet, ext string) *SampleFile {
+	return &SampleFile{
+		s:		s,
+		ext:		ext,
+		FUSEFile:	new(FUSEFile),
+	}
+}
+
+// the archive the node stands for, or "" if there isn't one
+func (r *SampleFile) source() string {
+	found, err := r.s.Find()
+	if err != nil {
+		log.Printf("error finding sample set %s: %v\n", r.s.Name, err)
+	}
+	if !found || err != nil || isLooseSet(r.s.Loc) || filepath.Ext(r.s.Loc) != r.ext {
+		return ""
+	}
+	return r.s.Loc
+}
+
+func (r *SampleFile) Attr() fuse.Attr {
+	return r.size.attr(func() (uint64, bool) {
+		return fileSize(r.source())
+	})
+}
+
+// TODO DRY?
+func (r *SampleFile) Open(req *fuse.OpenRequest, resp *fuse.OpenResponse, intr fuse.Intr) (h fuse.Handle, ferr fuse.Error) {
+	if (req.Flags & uint32(os.O_WRONLY | os.O_RDWR)) != 0 {		// ban writes
+		return nil, fuse.EPERM
+	}
+	filename := r.source()
+	if filename == "" {
+		return nil, fuse.ENOENT
+	}
+	ferr = r.open(filename)
+	if ferr != nil {
+		return nil, ferr
+	}
+	return r, nil
+}
+
+type LooseSampleFile struct {
+	s		*SampleSet
+	name	string
+	size		nodeSize
+	*FUSEFile
+}
+
+// because the *FUSEFile embed won't allocate itself
+func NewLooseSampleFile(s *SampleSet, name string) *LooseSampleFile {
+	return &LooseSampleFile{
+		s:		s,
+		name:	name,
+		FUSEFile:	new(FUSEFile),
+	}
+}
+
+// the file the node stands for, or "" if there isn't one
+func (r *LooseSampleFile) source() string {
+	found, err := r.s.Find()
+	if err != nil {
+		log.Printf("error finding sample set %s: %v\n", r.s.Name, err)
+	}
+	if !found || err != nil || !isLooseSet(r.s.Loc) {		// MAME will use the archive instead
+		return ""
+	}
+	return filepath.Join(r.s.Loc, r.name)
+}
+
+func (r *LooseSampleFile) Attr() fuse.Attr {
+	return r.size.attr(func() (uint64, bool) {
+		return fileSize(r.source())
+	})
+}
+
+// TODO DRY?
+func (r *LooseSampleFile) Open(req *fuse.OpenRequest, resp *fuse.OpenResponse, intr fuse.Intr) (h fuse.Handle, ferr fuse.Error) {
+	if (req.Flags & uint32(os.O_WRONLY | os.O_RDWR)) != 0 {		// ban writes
+		return nil, fuse.EPERM
+	}
+	filename := r.source()
+	if filename == "" {
 		return nil, fuse.ENOENT
 	}
-	ferr = r.open(r.g.ROMLoc)
+	ferr = r.open(filename)
 	if ferr != nil {
 		return nil, ferr
 	}
@@ -102,6 +421,7 @@ func (r *ROMFile) Open(req *fuse.OpenRequest, resp *fuse.OpenResponse, intr fuse
 type CHDFile struct {
 	g		*Game
 	name	string
+	size		nodeSize
 	*FUSEFile
 }
 
@@ -114,18 +434,227 @@ func NewCHDFile(g *Game, name string) *CHDFile {
 	}
 }
 
+// the file the node stands for, or "" if there isn't one (it's optional and missing)
+func (r *CHDFile) source() string {
+	found, err := r.g.Find()
+	if !found || err != nil {
+		return ""
+	}
+	return r.g.CHDLoc[r.name]
+}
+
+func (r *CHDFile) Attr() fuse.Attr {
+	return r.size.attr(func() (uint64, bool) {
+		return fileSize(r.source())
+	})
+}
+
 // TODO DRY?
 func (r *CHDFile) Open(req *fuse.OpenRequest, resp *fuse.OpenResponse, intr fuse.Intr) (h fuse.Handle, ferr fuse.Error) {
 	if (req.Flags & uint32(os.O_WRONLY | os.O_RDWR)) != 0 {		// ban writes
 		return nil, fuse.EPERM
 	}
+	filename := r.source()
+	if filename == "" {
+		return nil, fuse.ENOENT
+	}
+	ferr = r.open(filename)
+	if ferr != nil {
+		return nil, ferr
+	}
+	return r, nil
+}
+
+// a CHD's contents as a disk image; see raw.go
+type RawCHDFile struct {
+	g		*Game
+	name	string
+	ext		string
+	info		*rawCHDInfo
+}
+
+// what kind of image a CHD is and how big, once it's been opened; shared by the node for each kind so only one of them has to open it
+type rawCHDInfo struct {
+	lock		sync.Mutex
+	known	bool
+	ext		string		// "" if there's no raw view
+	size		int64
+}
+
+func (i *rawCHDInfo) get() (ext string, size int64, known bool) {
+	i.lock.Lock()
+	defer i.lock.Unlock()
+	return i.ext, i.size, i.known
+}
+
+func (i *rawCHDInfo) set(ext string, size int64) {
+	i.lock.Lock()
+	defer i.lock.Unlock()
+	i.ext = ext
+	i.size = size
+	i.known = true
+}
+
+func NewRawCHDFile(g *Game, name string, ext string, info *rawCHDInfo) *RawCHDFile {
+	return &RawCHDFile{
+		g:		g,
+		name:	name,
+		ext:		ext,
+		info:		info,
+	}
+}
+
+// opening the image only reads the CHD's header and metadata (and the map), so stat can do it too, but only the first time
+func (r *RawCHDFile) Attr() fuse.Attr {
+	if ext, size, known := r.info.get(); known {
+		if ext != r.ext {
+			return sizedAttr(0)
+		}
+		return sizedAttr(uint64(size))
+	}
+	img, ferr := r.image()
+	if ferr != nil {
+		return sizedAttr(0)
+	}
+	defer img.Close()
+	return sizedAttr(uint64(img.size))
+}
+
+func (r *RawCHDFile) Open(req *fuse.OpenRequest, resp *fuse.OpenResponse, intr fuse.Intr) (h fuse.Handle, ferr fuse.Error) {
+	if (req.Flags & uint32(os.O_WRONLY | os.O_RDWR)) != 0 {		// ban writes
+		return nil, fuse.EPERM
+	}
+	if ext, _, known := r.info.get(); known && ext != r.ext {		// one of the other kinds
+		return nil, fuse.ENOENT
+	}
+	img, ferr := r.image()
+	if ferr != nil {
+		return nil, ferr
+	}
+	return &RawHandle{
+		img:		img,
+	}, nil
+}
+
+func (r *RawCHDFile) image() (*rawImage, fuse.Error) {
 	found, err := r.g.Find()
 	if !found || err != nil {
 		return nil, fuse.ENOENT
 	}
-	ferr = r.open(r.g.CHDLoc[r.name])
+	loc, ok := r.g.CHDLoc[r.name]
+	if !ok {		// optional and missing
+		return nil, fuse.ENOENT
+	}
+	c, err := r.g.openCHDChain(loc)
+	if err != nil {
+		log.Printf("error opening CHD %s for raw view: %v\n", loc, err)
+		return nil, fuse.EIO
+	}
+	img, err := newRawImage(c)
+	if err != nil {
+		c.Close()
+		log.Printf("no raw view of CHD %s: %v\n", loc, err)
+		r.info.set("", 0)		// and there won't be next time either
+		return nil, fuse.ENOENT
+	}
+	r.info.set(img.ext, img.size)
+	if img.ext != r.ext {
+		img.Close()
+		return nil, fuse.ENOENT
+	}
+	return img, nil
+}
+
+type RawHandle struct {
+	img		*rawImage
+}
+
+func (h *RawHandle) Read(req *fuse.ReadRequest, resp *fuse.ReadResponse, intr fuse.Intr) fuse.Error {
+	resp.Data = make([]byte, req.Size)
+	n, err := h.img.ReadAt(resp.Data, req.Offset)
+	if err == io.EOF {
+		resp.Data = resp.Data[:n]
+	} else if err != nil {
+		log.Printf("error reading raw view of CHD: %v\n", err)
+		return fuse.EIO
+	}
+	return nil
+}
+
+func (h *RawHandle) Release(*fuse.ReleaseRequest, fuse.Intr) fuse.Error {
+	h.img.Close()
+	return nil
+}
+
+// the output of chdInfo() for a CHD
+type CHDInfoFile struct {
+	g		*Game
+	name	string
+	size		nodeSize
+}
+
+func NewCHDInfoFile(g *Game, name string) *CHDInfoFile {
+	return &CHDInfoFile{
+		g:		g,
+		name:	name,
+	}
+}
+
+func (r *CHDInfoFile) Attr() fuse.Attr {
+	return r.size.attr(func() (uint64, bool) {
+		info, ferr := r.info()
+		return uint64(len(info)), ferr == nil
+	})
+}
+
+func (r *CHDInfoFile) Open(req *fuse.OpenRequest, resp *fuse.OpenResponse, intr fuse.Intr) (h fuse.Handle, ferr fuse.Error) {
+	if (req.Flags & uint32(os.O_WRONLY | os.O_RDWR)) != 0 {		// ban writes
+		return nil, fuse.EPERM
+	}
+	info, ferr := r.info()
 	if ferr != nil {
 		return nil, ferr
 	}
-	return r, nil
+	return &BytesHandle{
+		data:	[]byte(info),
+	}, nil
+}
+
+func (r *CHDInfoFile) info() (string, fuse.Error) {
+	found, err := r.g.Find()
+	if !found || err != nil {
+		return "", fuse.ENOENT
+	}
+	loc, ok := r.g.CHDLoc[r.name]
+	if !ok {
+		return "", fuse.ENOENT
+	}
+	info, err := chdInfo(loc)
+	if err != nil {
+		log.Printf("error reading CHD %s for its info: %v\n", loc, err)
+		return "", fuse.EIO
+	}
+	return info, nil
+}
+
+// for files we make up in full when they're opened
+type BytesHandle struct {
+	data		[]byte
+}
+
+func (h *BytesHandle) Read(req *fuse.ReadRequest, resp *fuse.ReadResponse, intr fuse.Intr) fuse.Error {
+	if req.Offset >= int64(len(h.data)) {
+		resp.Data = nil
+		return nil
+	}
+	end := req.Offset + int64(req.Size)
+	if end > int64(len(h.data)) {
+		end = int64(len(h.data))
+	}
+	resp.Data = h.data[req.Offset:end]
+	return nil
+}
+
+func (h *BytesHandle) Release(*fuse.ReleaseRequest, fuse.Intr) fuse.Error {
+	return nil
 }
@@ -0,0 +1,180 @@
+// 18 october 2026
+package main
+
+import (
+	"testing"
+	"os"
+	"io"
+	"reflect"
+	"archive/zip"
+	"path/filepath"
+)
+
+// make dir the only ROM path, with the given files in it
+func testDirs(t *testing.T, files ...string) string {
+	dir := t.TempDir()
+	for _, f := range files {
+		err := os.WriteFile(filepath.Join(dir, f), nil, 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	oldDirs := dirs
+	dirs = []string{dir}
+	archivesOnDisk = map[string]map[string]bool{}
+	t.Cleanup(func() {
+		dirs = oldDirs
+		archivesOnDisk = map[string]map[string]bool{}
+	})
+	return dir
+}
+
+// a set that's only on disk as a 7z still needs a .zip node if it might be synthesized
+func TestArchiveNodeExts(t *testing.T) {
+	testDirs(t, "only7z.7z", "both.zip", "both.7z")
+	oldSynth, oldExtras := synthesize, extrasPolicy
+	defer func() {
+		synthesize, extrasPolicy = oldSynth, oldExtras
+	}()
+	cat := &Catalog{
+		Games:	map[string]*Game{},
+	}
+	for _, tc := range []struct {
+		name		string
+		synthesize	bool
+		extras		string
+		want		[]string
+	}{
+		{"only7z",		false,	strictExtras,	[]string{".7z"}},
+		{"only7z",		true,	strictExtras,	[]string{".zip", ".7z"}},
+		{"only7z",		false,	filterExtras,	[]string{".zip", ".7z"}},
+		{"both",		false,	strictExtras,	[]string{".zip", ".7z"}},
+		{"missing",	false,	strictExtras,	[]string{".zip"}},
+	} {
+		synthesize, extrasPolicy = tc.synthesize, tc.extras
+		g := &Game{
+			Name:	tc.name,
+			cat:		cat,
+		}
+		if got := g.archiveNodeExts(); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%s with -synthesize=%v -extras %s: got %v, want %v", tc.name, tc.synthesize, tc.extras, got, tc.want)
+		}
+	}
+}
+
+// only sets that are directories on disk get loose ROM nodes
+func TestLooseOnDisk(t *testing.T) {
+	dir := testDirs(t, "zipped.zip", "file")
+	elsewhere := t.TempDir()
+	err := os.Mkdir(filepath.Join(dir, "loose"), 0755)
+	if err == nil {
+		err = os.Symlink(elsewhere, filepath.Join(dir, "linked"))
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	cat := &Catalog{
+		Games:	map[string]*Game{},
+	}
+	for _, tc := range []struct {
+		name	string
+		want	bool
+	}{
+		{"loose",		true},
+		{"linked",	true},
+		{"zipped",	false},
+		{"file",		false},
+		{"missing",	false},
+	} {
+		g := &Game{
+			Name:	tc.name,
+			cat:		cat,
+		}
+		if got := g.looseOnDisk(); got != tc.want {
+			t.Errorf("%s: looseOnDisk() is %v, want %v", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestROMFileAttr(t *testing.T) {
+	dir := t.TempDir()
+	zipname := filepath.Join(dir, "parent.zip")
+	writeZip(t, zipname, "a.bin", "b.bin")
+	fi, err := os.Stat(zipname)
+	if err != nil {
+		t.Fatal(err)
+	}
+	g := loadTestSets(t, dir)
+	synthesize = false
+	parent := testGame(t, g, "parent")
+	if size := NewROMFile(parent, ".zip").Attr().Size; size != uint64(fi.Size()) {
+		t.Errorf("size of set on disk is %d, want %d", size, fi.Size())
+	}
+	if size := NewROMFile(parent, ".7z").Attr().Size; size != 0 {
+		t.Errorf("size of a kind of archive the set isn't in is %d, want 0", size)
+	}
+
+	// nothing is looked at again once it's known, even if it changes
+	node := NewROMFile(parent, ".zip")
+	node.Attr()
+	err = os.Remove(zipname)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if size := node.Attr().Size; size != uint64(fi.Size()) {
+		t.Errorf("size changed from %d to %d on the second stat", fi.Size(), size)
+	}
+}
+
+func TestSynthAttr(t *testing.T) {
+	dir := t.TempDir()
+	writeZip(t, filepath.Join(dir, "parent.zip"), "a.bin", "b.bin", "d.bin")
+	g := loadTestSets(t, dir)
+	synthesize = false
+	extrasPolicy = filterExtras
+	parent := testGame(t, g, "parent")
+	size := NewROMFile(parent, ".zip").Attr().Size
+	if parent.Synth == nil {
+		t.Fatal("set with an extra file not synthesized")
+	}
+	if size != uint64(parent.Synth.size) {
+		t.Errorf("size of synthesized set is %d, want %d", size, parent.Synth.size)
+	}
+	r := parent.Synth.NewReader()
+	defer r.Close()
+	zr, err := zip.NewReader(r, parent.Synth.size)
+	if err != nil {
+		t.Fatalf("can't read the synthesized zip: %v", err)
+	}
+	if len(zr.File) != 2 {
+		t.Errorf("synthesized zip has %d files, want 2 (without d.bin)", len(zr.File))
+	}
+	data, err := io.ReadAll(io.NewSectionReader(r, 0, int64(size)))
+	if err != nil || uint64(len(data)) != size {
+		t.Errorf("read %d bytes of synthesized zip (%v), want the %d stat said", len(data), err, size)
+	}
+}
+
+// stats come in concurrently, and a clone's Find() finds its parent too
+func TestConcurrentAttr(t *testing.T) {
+	dir := t.TempDir()
+	writeZip(t, filepath.Join(dir, "parent.zip"), "a.bin", "b.bin")
+	writeZip(t, filepath.Join(dir, "clone.zip"), "c.bin")
+	g := loadTestSets(t, dir)
+	synthesize = false
+	var nodes []*ROMFile
+	for i := 0; i < 8; i++ {
+		nodes = append(nodes, NewROMFile(testGame(t, g, "clone"), ".zip"), NewROMFile(testGame(t, g, "parent"), ".zip"))
+	}
+	sizes := make(chan uint64)
+	for _, n := range nodes {
+		go func(n *ROMFile) {
+			sizes <- n.Attr().Size
+		}(n)
+	}
+	for range nodes {
+		if size := <-sizes; size == 0 {
+			t.Errorf("set not found by one of the concurrent stats")
+		}
+	}
+}
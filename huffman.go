@@ -0,0 +1,177 @@
+// 18 october 2026
+package main
+
+import (
+	"fmt"
+)
+
+// CHD v5 uses MAME's own canonical Huffman coding both for its hunk maps and as a codec of its own ("huff")
+// this is a straight port of the decoding half of MAME's huffman.cpp
+
+// reads bits most significant first; reading past the end gives zeroes, like MAME's bitstream_in
+type bitReader struct {
+	data		[]byte
+	pos		int		// in bits
+}
+
+// n is at most 32
+func (b *bitReader) peek(n int) uint32 {
+	i := b.pos / 8
+	var v uint64
+	for j := 0; j < 5; j++ {
+		v <<= 8
+		if i + j < len(b.data) {
+			v |= uint64(b.data[i + j])
+		}
+	}
+	return uint32((v >> uint(40 - b.pos % 8 - n)) & (1 << uint(n) - 1))
+}
+
+func (b *bitReader) read(n int) uint32 {
+	v := b.peek(n)
+	b.pos += n
+	return v
+}
+
+func (b *bitReader) overflow() bool {
+	return b.pos > len(b.data) * 8
+}
+
+// the byte offset just past what's been read
+func (b *bitReader) bytePos() int {
+	return (b.pos + 7) / 8
+}
+
+type huffmanDecoder struct {
+	maxbits	int
+	numbits	[]uint8		// per symbol; 0 if the symbol is unused
+	lookup	[]uint16		// indexed by the next maxbits bits: symbol << 5 | length
+}
+
+func newHuffmanDecoder(numcodes int, maxbits int) *huffmanDecoder {
+	return &huffmanDecoder{
+		maxbits:	maxbits,
+		numbits:	make([]uint8, numcodes),
+	}
+}
+
+func (h *huffmanDecoder) build() error {
+	// assign canonical codes
+	var histo [33]uint32
+	for _, n := range h.numbits {
+		if int(n) > h.maxbits {
+			return fmt.Errorf("huffman code length %d over maximum %d", n, h.maxbits)
+		}
+		histo[n]++
+	}
+	var start uint32
+	for length := 32; length > 0; length-- {
+		next := (start + histo[length]) >> 1
+		if length != 1 && next * 2 != start + histo[length] {
+			return fmt.Errorf("invalid huffman tree")
+		}
+		histo[length] = start
+		start = next
+	}
+
+	// and build the lookup table from them
+	h.lookup = make([]uint16, 1 << uint(h.maxbits))
+	for sym, n := range h.numbits {
+		if n == 0 {
+			continue
+		}
+		code := histo[n]
+		histo[n]++
+		shift := uint(h.maxbits - int(n))
+		for i := code << shift; i < (code + 1) << shift; i++ {
+			h.lookup[i] = uint16(sym) << 5 | uint16(n)
+		}
+	}
+	return nil
+}
+
+func (h *huffmanDecoder) decode(b *bitReader) int {
+	l := h.lookup[b.peek(h.maxbits)]
+	b.pos += int(l & 0x1F)
+	return int(l >> 5)
+}
+
+// the simple run-length encoded form used for the v5 hunk map
+func (h *huffmanDecoder) importTreeRLE(b *bitReader) error {
+	nbits := 3
+	if h.maxbits >= 16 {
+		nbits = 5
+	} else if h.maxbits >= 8 {
+		nbits = 4
+	}
+	for cur := 0; cur < len(h.numbits); {
+		n := b.read(nbits)
+		if n != 1 {
+			h.numbits[cur] = uint8(n)
+			cur++
+			continue
+		}
+		n = b.read(nbits)
+		if n == 1 {
+			h.numbits[cur] = uint8(n)
+			cur++
+			continue
+		}
+		rep := int(b.read(nbits)) + 3
+		if cur + rep > len(h.numbits) {
+			return fmt.Errorf("huffman tree run overflows the table")
+		}
+		for ; rep > 0; rep-- {
+			h.numbits[cur] = uint8(n)
+			cur++
+		}
+	}
+	return h.build()
+}
+
+// the form where the code lengths are themselves huffman coded, used by the huff codec
+func (h *huffmanDecoder) importTreeHuffman(b *bitReader) error {
+	small := newHuffmanDecoder(24, 6)
+	small.numbits[0] = uint8(b.read(3))
+	start := int(b.read(3)) + 1
+	count := 0
+	for i := 1; i < 24; i++ {
+		if i < start || count == 7 {
+			small.numbits[i] = 0
+		} else {
+			count = int(b.read(3))
+			if count != 7 {
+				small.numbits[i] = uint8(count)
+			}
+		}
+	}
+	err := small.build()
+	if err != nil {
+		return err
+	}
+
+	rlefullbits := 0
+	for temp := len(h.numbits) - 9; temp != 0; temp >>= 1 {
+		rlefullbits++
+	}
+	last := uint8(0)
+	cur := 0
+	for cur < len(h.numbits) {
+		v := small.decode(b)
+		if v != 0 {
+			last = uint8(v - 1)
+			h.numbits[cur] = last
+			cur++
+			continue
+		}
+		count := int(b.read(3)) + 2
+		if count == 7 + 2 {
+			count += int(b.read(rlefullbits))
+		}
+		for ; count != 0 && cur < len(h.numbits); count-- {
+			h.numbits[cur] = last
+			cur++
+		}
+	}
+	return h.build()
+}
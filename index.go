@@ -0,0 +1,166 @@
+// 18 october 2026
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"log"
+)
+
+// the ROM index knows what every archive under dirs contains by content, so ROMs can be found no matter what they or their archives are called
+// building it only reads archive directories; the SHA-1 of an entry is only worked out (or taken from the verification cache) the first time someone asks for its CRC-32 and size
+// TODO loose sets aren't indexed because getting their CRC-32s means reading every file
+
+type indexKey struct {
+	size		uint64
+	crc		uint32
+}
+
+type indexBucket struct {
+	lock		sync.Mutex		// held while hashing, so only lookups of the same CRC-32 and size wait for the disk
+	unhashed	[]romLocation
+	bySHA1	map[string][]romLocation
+}
+
+var romIndex map[indexKey]*indexBucket		// nil if we aren't using one
+var romIndexLock sync.Mutex		// only for the map itself
+
+func buildROMIndex() {
+	romIndex = map[indexKey]*indexBucket{}
+	narchives, nroms := 0, 0
+	for _, d := range dirs {
+		filepath.Walk(d, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				log.Printf("error scanning %s for the ROM index: %v\n", path, err)
+				return nil
+			}
+			ext := strings.ToLower(filepath.Ext(path))
+			if info.IsDir() || (ext != ".zip" && ext != ".7z") {
+				return nil
+			}
+			a, err := openArchive(path)
+			if err != nil {
+				log.Printf("could not open %s for the ROM index: %v\n", path, err)
+				return nil
+			}
+			defer a.Close()
+			for _, f := range a.Files() {
+				crc, _ := f.CRC32()		// free for archives
+				key := indexKey{f.Size(), crc}
+				b, ok := romIndex[key]
+				if !ok {
+					b = &indexBucket{
+						bySHA1:	map[string][]romLocation{},
+					}
+					romIndex[key] = b
+				}
+				b.unhashed = append(b.unhashed, romLocation{
+					archive:	path,
+					entry:	f.Name(),
+				})
+				nroms++
+			}
+			narchives++
+			return nil
+		})
+	}
+	log.Printf("indexed %d ROMs in %d archives\n", nroms, narchives)
+}
+
+// hash everything in the bucket we haven't yet
+// b.lock must be held
+func (b *indexBucket) hash() {
+	for _, l := range b.unhashed {
+		a, f, err := l.open()
+		if err != nil {
+			log.Printf("error opening %s in %s from the ROM index: %v\n", l.entry, l.archive, err)
+			continue
+		}
+		sum, err := f.Sums()
+		a.Close()
+		if err != nil {
+			log.Printf("error hashing %s in %s from the ROM index: %v\n", l.entry, l.archive, err)
+			continue
+		}
+		b.bySHA1[sum.SHA1] = append(b.bySHA1[sum.SHA1], l)
+	}
+	b.unhashed = nil
+}
+
+// everywhere the given ROM can be found according to the index
+func lookupROM(rom *ROM) []romLocation {
+	if rom.CRC32 == "" {		// can't look it up
+		return nil
+	}
+	crc, err := strconv.ParseUint(rom.CRC32, 16, 32)
+	if err != nil {
+		log.Fatalf("string convert error reading crc32 (%q): %v", rom.CRC32, err)
+	}
+
+	romIndexLock.Lock()
+	b, ok := romIndex[indexKey{rom.Size, uint32(crc)}]
+	romIndexLock.Unlock()
+	if !ok {
+		return nil
+	}
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if rom.SHA1 == "" {		// CRC-32 and size is all we have to go by
+		var all []romLocation
+		all = append(all, b.unhashed...)
+		for _, l := range b.bySHA1 {
+			all = append(all, l...)
+		}
+		return all
+	}
+	b.hash()
+	return b.bySHA1[strings.ToLower(rom.SHA1)]
+}
+
+// the CHD index knows the header SHA-1 of every CHD under dirs, so parent CHDs can be found wherever they are
+// with -index it's built at startup and used to find every CHD; otherwise it's only built if a diff CHD needs its parent
+var chdIndex map[[20]byte][]string
+var chdIndexOnce sync.Once
+
+func buildCHDIndex() {
+	chdIndex = map[[20]byte][]string{}
+	n := 0
+	for _, d := range dirs {
+		filepath.Walk(d, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				log.Printf("error scanning %s for the CHD index: %v\n", path, err)
+				return nil
+			}
+			if info.IsDir() || !strings.EqualFold(filepath.Ext(path), ".chd") {
+				return nil
+			}
+			f, err := os.Open(path)
+			if err != nil {
+				log.Printf("could not open %s for the CHD index: %v\n", path, err)
+				return nil
+			}
+			defer f.Close()
+			hdr, err := readCHDHeader(f)
+			if err != nil {
+				log.Printf("could not read %s for the CHD index: %v\n", path, err)
+				return nil
+			}
+			if hdr.Version < 3 {		// no SHA-1
+				return nil
+			}
+			chdIndex[hdr.SHA1] = append(chdIndex[hdr.SHA1], path)
+			n++
+			return nil
+		})
+	}
+	log.Printf("indexed %d CHDs\n", n)
+}
+
+// every CHD whose header has the given SHA-1
+func lookupCHD(sha1 [20]byte) []string {
+	chdIndexOnce.Do(buildCHDIndex)
+	return chdIndex[sha1]
+}
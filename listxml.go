@@ -0,0 +1,105 @@
+// 18 october 2026
+package main
+
+import (
+	"os"
+	"io"
+	"os/exec"
+	"bufio"
+	"encoding/xml"
+	"strings"
+	"code.google.com/p/rsc/fuse"
+	"log"
+)
+
+// -listxml output has changed a lot since 0.84, when it was introduced:
+// - the root is <mame>, or <mess> from MESS before it was merged in
+// - sets are <game> before 0.162 and <machine> after
+// - before isbios existed, BIOS sets were only marked runnable="no"
+// - old enough files give disks an md5 and no sha1, and sometimes ROMs only a crc
+// - every version adds elements we don't care about (<display>, <softwarelist>, <slot>, ...) which are skipped
+// a software list file (root <softwarelist>, or <softwarelists> from -listsoftware) is loaded as software lists instead
+
+// the file can be compressed (see decompress.go)
+// if it isn't XML but can be run, it's MAME itself, so we ask it for the XML; that way the catalog always matches the MAME being used
+func openListXML(filename string) (io.ReadCloser, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	r := bufio.NewReader(f)
+	start, _ := r.Peek(512)
+	if c, err := openCompressed(filename, start); c != nil || err != nil {
+		f.Close()
+		return c, err
+	}
+	if isXML(start) || isCMPDat(start) || fi.Mode() & 0111 == 0 {
+		return &bufferedFile{r, f}, nil
+	}
+	f.Close()
+	log.Printf("running %s -listxml\n", filename)
+	return runCommand(exec.Command(filename, "-listxml"))
+}
+
+type bufferedFile struct {
+	*bufio.Reader
+	f	*os.File
+}
+
+func (b *bufferedFile) Close() error {
+	return b.f.Close()
+}
+
+type listXMLLoader struct{}
+
+func (listXMLLoader) load(d *xml.Decoder, root xml.StartElement, filename string, fstree *fuse.Tree) error {
+	return eachChild(d, func(e xml.StartElement) error {
+		switch strings.ToLower(e.Name.Local) {
+		case "game", "machine":
+			this := new(Game)
+			err := d.DecodeElement(this, &e)
+			if err != nil {
+				return err
+			}
+			this.fixOldListXML()
+			games[this.Name] = this
+			return nil
+		}
+		return d.Skip()
+	})
+}
+
+type softwareListLoader struct{}
+
+func (softwareListLoader) load(d *xml.Decoder, root xml.StartElement, filename string, fstree *fuse.Tree) error {
+	var list softwareList
+	err := d.DecodeElement(&list, &root)
+	if err != nil {
+		return err
+	}
+	addSoftwareList(&list, filename, fstree)
+	return nil
+}
+
+// what -listsoftware gives
+type softwareListsLoader struct{}
+
+func (softwareListsLoader) load(d *xml.Decoder, root xml.StartElement, filename string, fstree *fuse.Tree) error {
+	return eachChild(d, func(e xml.StartElement) error {
+		if strings.ToLower(e.Name.Local) != "softwarelist" {
+			return d.Skip()
+		}
+		return softwareListLoader{}.load(d, e, filename, fstree)
+	})
+}
+
+func (g *Game) fixOldListXML() {
+	if g.Runnable == "no" && g.IsBIOS == "" && g.IsDevice != yes {
+		g.IsBIOS = yes
+	}
+}
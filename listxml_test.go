@@ -0,0 +1,120 @@
+// 18 october 2026
+package main
+
+import (
+	"testing"
+	"os"
+	"io"
+	"strings"
+	"path/filepath"
+	"code.google.com/p/rsc/fuse"
+)
+
+// load filename in place of whatever catalog was loaded before
+func loadTestCatalog(t *testing.T, filename string) map[string]*Game {
+	machines = &Catalog{
+		Games:	map[string]*Game{},
+	}
+	games = machines.Games
+	softwareLists = map[string]*Catalog{}
+	readCatalogFile(filename, new(fuse.Tree))
+	return games
+}
+
+func testGame(t *testing.T, games map[string]*Game, name string) *Game {
+	g, ok := games[name]
+	if !ok {
+		t.Fatalf("%s not loaded", name)
+	}
+	return g
+}
+
+// one of each generation of -listxml; see the comment at the top of listxml.go
+func TestListXMLGenerations(t *testing.T) {
+	// <game>, BIOS sets only marked runnable="no", disks with only an MD5, ROMs with only a CRC-32
+	g := loadTestCatalog(t, "testdata/listxml/mame0084.xml")
+	if len(g) != 3 {
+		t.Errorf("0.84: %d sets loaded, want 3", len(g))
+	}
+	if testGame(t, g, "neogeo").IsBIOS != yes {
+		t.Errorf("0.84: runnable=\"no\" set isn't a BIOS")
+	}
+	if kof98 := testGame(t, g, "kof98"); kof98.IsBIOS != "" || kof98.ROMOf != "neogeo" || kof98.ROMs[1].Merge != "neo-geo.rom" {
+		t.Errorf("0.84: wrong kof98 %+v", kof98)
+	}
+	area51 := testGame(t, g, "area51")
+	if len(area51.CHDs) != 1 || area51.CHDs[0].SHA1 != "" || area51.CHDs[0].MD5 != "a8cc9c7c5e8d9d5f6b6ab5d1e56b5c8a" {
+		t.Errorf("0.84: wrong disk %+v", area51.CHDs)
+	}
+	if rom := testGame(t, g, "neogeo").ROMs[0]; rom.CRC32 != "9036d879" || rom.SHA1 != "" || rom.Size != 131072 {
+		t.Errorf("0.84: wrong CRC-32-only ROM %+v", rom)
+	}
+
+	// <mess>, and devices are runnable="no" too but aren't BIOSes
+	g = loadTestCatalog(t, "testdata/listxml/mess0150.xml")
+	if len(g) != 3 {
+		t.Errorf("0.150 MESS: %d sets loaded, want 3", len(g))
+	}
+	if m68000 := testGame(t, g, "m68000"); m68000.IsBIOS != "" || m68000.IsDevice != yes {
+		t.Errorf("0.150 MESS: device is a BIOS")
+	}
+	if testGame(t, g, "megacd").IsBIOS != yes {
+		t.Errorf("0.150 MESS: runnable=\"no\" set isn't a BIOS")
+	}
+	if genesis := testGame(t, g, "genesis"); len(genesis.DeviceRefs) != 1 || genesis.DeviceRefs[0].Name != "m68000" || genesis.IsBIOS != "" {
+		t.Errorf("0.150 MESS: wrong genesis %+v", genesis)
+	}
+
+	// <machine> with isbios
+	g = loadTestCatalog(t, "testdata/listxml/mame0250.xml")
+	if len(g) != 2 {
+		t.Errorf("0.250: %d sets loaded, want 2", len(g))
+	}
+	naomi := testGame(t, g, "naomi")
+	if naomi.IsBIOS != yes || len(naomi.BIOSSets) != 1 || naomi.ROMs[0].BIOS != "bios0" {
+		t.Errorf("0.250: wrong BIOS %+v", naomi)
+	}
+	ikaruga := testGame(t, g, "ikaruga")
+	if ikaruga.IsBIOS != "" || len(ikaruga.CHDs) != 1 || ikaruga.CHDs[0].SHA1 == "" || ikaruga.CHDs[0].MD5 != "" || len(ikaruga.Samples) != 1 {
+		t.Errorf("0.250: wrong ikaruga %+v", ikaruga)
+	}
+}
+
+func writeStub(t *testing.T, script string) string {
+	filename := filepath.Join(t.TempDir(), "mame")
+	err := os.WriteFile(filename, []byte("#!/bin/sh\n" + script), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return filename
+}
+
+// given MAME itself, we run it with -listxml
+func TestListXMLCommand(t *testing.T) {
+	xml, err := filepath.Abs("testdata/listxml/mame0250.xml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	stub := writeStub(t, "[ \"$1\" = -listxml ] || exit 1\ncat '" + xml + "'\n")
+	g := loadTestCatalog(t, stub)
+	if len(g) != 2 || g["naomi"] == nil || g["ikaruga"] == nil {
+		t.Errorf("loaded %d sets from the stub, want naomi and ikaruga", len(g))
+	}
+}
+
+// and if it fails, that has to come out as an error, not as a catalog that stops early
+func TestListXMLCommandFails(t *testing.T) {
+	stub := writeStub(t, "echo '<mame build=\"0.250\">'\nexit 3\n")
+	r, err := openListXML(stub)
+	if err != nil {
+		t.Fatalf("could not start the stub: %v", err)
+	}
+	_, readErr := io.ReadAll(r)
+	closeErr := r.Close()
+	if readErr == nil || !strings.Contains(readErr.Error(), "exit status 3") {
+		t.Errorf("reading gave %v, want the exit status", readErr)
+	}
+	if closeErr == nil || !strings.Contains(closeErr.Error(), "exit status 3") {
+		t.Errorf("closing gave %v, want the exit status", closeErr)
+	}
+}
@@ -0,0 +1,84 @@
+// 18 october 2026
+package main
+
+import (
+	"encoding/xml"
+	"strings"
+	"path/filepath"
+	"code.google.com/p/rsc/fuse"
+	"log"
+)
+
+// Logiqx XML DAT files (what ClrMamePro, RomVault, and friends use, and how FBNeo, No-Intro, and Redump publish their sets) are close enough to -listxml that their <game>s and <machine>s decode straight into Game
+// the differences: the root is <datafile>, there's a <header> about the DAT itself, and RomVault can group sets in <dir>s
+// RomVault keeps the sets in a <dir> in a folder of that name, so that's where we look for them; the mount still has them all together since that's what MAME wants
+// set names still have to be unique across <dir>s, since MAME only goes by the name
+
+type logiqxHeader struct {
+	Name		string	`xml:"name"`
+	Description	string	`xml:"description"`
+	Version		string	`xml:"version"`
+}
+
+type logiqxLoader struct{}
+
+func (logiqxLoader) load(d *xml.Decoder, root xml.StartElement, filename string, fstree *fuse.Tree) error {
+	r := &logiqxReader{
+		d:			d,
+		filename:		filename,
+		dirOf:		map[string]string{},
+	}
+	return r.dir("")
+}
+
+type logiqxReader struct {
+	d			*xml.Decoder
+	filename		string
+	dirOf		map[string]string		// which <dir> each set came from, to catch two with the same name
+}
+
+// read the children of the root or a <dir>; path is the names of the <dir>s we're in
+func (r *logiqxReader) dir(path string) error {
+	d := r.d
+	return eachChild(d, func(e xml.StartElement) error {
+		switch strings.ToLower(e.Name.Local) {
+		case "header":
+			var h logiqxHeader
+			err := d.DecodeElement(&h, &e)
+			if err != nil {
+				return err
+			}
+			log.Printf("loading DAT %s (%s) version %s\n", h.Name, h.Description, h.Version)
+			return nil
+		case "game", "machine":
+			this := new(Game)
+			err := d.DecodeElement(this, &e)
+			if err != nil {
+				return err
+			}
+			if prev, ok := r.dirOf[this.Name]; ok {		// see above
+				log.Printf("warning: %s: set %s in %s replaces the one in %s\n", r.filename, this.Name, dirName(path), dirName(prev))
+			}
+			r.dirOf[this.Name] = path
+			this.folder = filepath.FromSlash(strings.TrimPrefix(path, "/"))
+			games[this.Name] = this
+			return nil
+		case "dir":
+			name := ""
+			for _, a := range e.Attr {
+				if a.Name.Local == "name" {
+					name = a.Value
+				}
+			}
+			return r.dir(path + "/" + name)
+		}
+		return d.Skip()
+	})
+}
+
+func dirName(path string) string {
+	if path == "" {
+		return "the top level"
+	}
+	return "<dir> " + strings.TrimPrefix(path, "/")
+}
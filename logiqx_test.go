@@ -0,0 +1,79 @@
+// 18 october 2026
+package main
+
+import (
+	"testing"
+	"bytes"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"path/filepath"
+	"code.google.com/p/rsc/fuse"
+)
+
+func TestLogiqx(t *testing.T) {
+	var logged bytes.Buffer
+	log.SetOutput(&logged)
+	defer log.SetOutput(os.Stderr)
+	g := loadTestCatalog(t, "testdata/logiqx/fbneo.dat")
+	if len(g) != 3 {
+		t.Errorf("%d sets loaded, want 3", len(g))
+	}
+	if !strings.Contains(logged.String(), "loading DAT Test Arcade Games (Test Arcade Games (a few sets in dirs)) version 1.0") {
+		t.Errorf("header not logged; log was %q", logged.String())
+	}
+	sfiii := testGame(t, g, "sfiii")
+	if len(sfiii.ROMs) != 1 || len(sfiii.CHDs) != 1 || sfiii.CHDs[0].SHA1 != "606e62cc5f46275e366e7dbb412dbaeb7e54cd0c" {
+		t.Errorf("wrong sfiii %+v", sfiii)
+	}
+
+	// sets in nested <dir>s are loaded too
+	sf2ua := testGame(t, g, "sf2ua")
+	if sf2ua.CloneOf != "sf2" || sf2ua.ROMOf != "sf2" {
+		t.Errorf("wrong sf2ua %+v", sf2ua)
+	}
+
+	// and the second sf2 replaces the first, but not without saying so
+	if sf2 := testGame(t, g, "sf2"); len(sf2.ROMs) != 1 || sf2.ROMs[0].Name != "sf2_30.11e" {
+		t.Errorf("wrong sf2 %+v", sf2)
+	}
+	if !strings.Contains(logged.String(), "set sf2 in <dir> old replaces the one in <dir> capcom") {
+		t.Errorf("duplicate set not warned about; log was %q", logged.String())
+	}
+}
+
+// sets in <dir>s are looked for in folders of the same names
+func TestLogiqxFolders(t *testing.T) {
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+	dir := testDirs(t)
+	folder := filepath.Join(dir, "capcom", "cps1 clones")
+	err := os.MkdirAll(folder, 0755)
+	if err == nil {
+		err = os.WriteFile(filepath.Join(folder, "sf2ua.7z"), nil, 0644)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	oldSynth := synthesize
+	synthesize = false
+	defer func() {
+		synthesize = oldSynth
+	}()
+	g := loadTestCatalog(t, "testdata/logiqx/fbneo.dat")
+	machines.prepare(new(fuse.Tree))
+	sf2ua := testGame(t, g, "sf2ua")
+	if want := filepath.Join(dir, "capcom", "cps1 clones", "sf2ua.zip"); sf2ua.filename_ROM(dir, ".zip") != want {
+		t.Errorf("sf2ua looked for at %s, want %s", sf2ua.filename_ROM(dir, ".zip"), want)
+	}
+	if exts := sf2ua.archiveNodeExts(); len(exts) != 1 || exts[0] != ".7z" {
+		t.Errorf("sf2ua in its folder shown as %v, want just .7z", exts)
+	}
+	if want := filepath.Join(dir, "sfiii.zip"); testGame(t, g, "sfiii").filename_ROM(dir, ".zip") != want {
+		t.Errorf("sfiii not looked for at the top, %s", want)
+	}
+	if got, want := sf2ua.diskPath("sf2"), filepath.Join("old", "sf2"); got != want {		// the sf2 that won
+		t.Errorf("sf2ua's parent looked for at %s, want %s", got, want)
+	}
+}
@@ -0,0 +1,85 @@
+// 18 october 2026
+package main
+
+import (
+	"os"
+	"io"
+	"path/filepath"
+	"strings"
+)
+
+// a loose set is a plain directory named after the game with the ROMs sitting in it unarchived, which MAME also accepts
+// CHDs live in the same directory, so those are not considered part of the set
+type looseDir struct {
+	dirname	string
+	files		[]archiveFile
+}
+
+type looseFile struct {
+	filename	string
+	fi		os.FileInfo
+}
+
+func isLooseSet(filename string) bool {
+	fi, err := os.Stat(filename)
+	return err == nil && fi.IsDir()
+}
+
+// the returned error satisfies os.IsNotExist() if the directory isn't there
+func openLooseDir(dirname string) (*looseDir, error) {
+	d, err := os.Open(dirname)
+	if err != nil {
+		return nil, err
+	}
+	defer d.Close()
+	fis, err := d.Readdir(-1)
+	if err != nil {		// including if dirname is a file and not a directory
+		return nil, err
+	}
+	a := &looseDir{
+		dirname:	dirname,
+	}
+	for _, fi := range fis {
+		if !fi.Mode().IsRegular() || strings.EqualFold(filepath.Ext(fi.Name()), ".chd") {
+			continue
+		}
+		a.files = append(a.files, &looseFile{
+			filename:	filepath.Join(dirname, fi.Name()),
+			fi:		fi,
+		})
+	}
+	return a, nil
+}
+
+func (a *looseDir) Files() []archiveFile {
+	return a.files
+}
+
+func (a *looseDir) Close() error {
+	return nil
+}
+
+func (f *looseFile) Name() string {
+	return f.fi.Name()
+}
+
+func (f *looseFile) Size() uint64 {
+	return uint64(f.fi.Size())
+}
+
+// unlike archives, a directory doesn't know the CRC-32 of its files for us
+func (f *looseFile) CRC32() (uint32, error) {
+	sum, err := f.Sums()
+	if err != nil {
+		return 0, err
+	}
+	return sum.CRC32, nil
+}
+
+func (f *looseFile) Sums() (*cacheSum, error) {
+	return cachedSums(f.filename, f.fi, "", f.fi.Size(), nil, f.Open)
+}
+
+func (f *looseFile) Open() (io.ReadCloser, error) {
+	return os.Open(f.filename)
+}
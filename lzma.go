@@ -0,0 +1,291 @@
+// 18 october 2026
+package main
+
+import (
+	"fmt"
+)
+
+// CHD's lzma codec stores each hunk as a bare LZMA stream: no header, no end marker, and always lc=3 lp=0 pb=2
+// the whole hunk is decoded into one buffer, so the dictionary size doesn't matter and distances are just offsets back into the output
+// this is the decoder from the LZMA SDK, minus everything CHD doesn't use
+
+const (
+	lzmaLC		= 3
+	lzmaLP		= 0
+	lzmaPB		= 2
+	lzmaStates	= 12
+	lzmaProbInit	= 1024
+)
+
+type lzmaRangeDecoder struct {
+	in		[]byte
+	pos		int
+	rng		uint32
+	code		uint32
+	overrun	bool
+}
+
+func (d *lzmaRangeDecoder) next() uint32 {
+	if d.pos >= len(d.in) {
+		d.overrun = true
+		return 0
+	}
+	d.pos++
+	return uint32(d.in[d.pos - 1])
+}
+
+func (d *lzmaRangeDecoder) init(in []byte) error {
+	d.in = in
+	d.rng = 0xFFFFFFFF
+	if d.next() != 0 {
+		return fmt.Errorf("bad LZMA stream")
+	}
+	for i := 0; i < 4; i++ {
+		d.code = d.code << 8 | d.next()
+	}
+	return nil
+}
+
+func (d *lzmaRangeDecoder) normalize() {
+	if d.rng < 1 << 24 {
+		d.rng <<= 8
+		d.code = d.code << 8 | d.next()
+	}
+}
+
+func (d *lzmaRangeDecoder) bit(p *uint16) uint32 {
+	bound := (d.rng >> 11) * uint32(*p)
+	var b uint32
+	if d.code < bound {
+		d.rng = bound
+		*p += (2048 - *p) >> 5
+	} else {
+		d.rng -= bound
+		d.code -= bound
+		*p -= *p >> 5
+		b = 1
+	}
+	d.normalize()
+	return b
+}
+
+func (d *lzmaRangeDecoder) direct(n uint) uint32 {
+	var res uint32
+	for ; n > 0; n-- {
+		d.rng >>= 1
+		d.code -= d.rng
+		t := 0 - (d.code >> 31)
+		d.code += d.rng & t
+		res = res << 1 + t + 1
+		d.normalize()
+	}
+	return res
+}
+
+func (d *lzmaRangeDecoder) tree(p []uint16, n uint) uint32 {
+	m := uint32(1)
+	for i := uint(0); i < n; i++ {
+		m = m << 1 | d.bit(&p[m])
+	}
+	return m - (1 << n)
+}
+
+func (d *lzmaRangeDecoder) reverseTree(p []uint16, n uint) uint32 {
+	m := uint32(1)
+	var sym uint32
+	for i := uint(0); i < n; i++ {
+		b := d.bit(&p[m])
+		m = m << 1 | b
+		sym |= b << i
+	}
+	return sym
+}
+
+type lzmaLenDecoder struct {
+	choice	uint16
+	choice2	uint16
+	low		[1 << lzmaPB][1 << 3]uint16
+	mid		[1 << lzmaPB][1 << 3]uint16
+	high		[1 << 8]uint16
+}
+
+func (l *lzmaLenDecoder) init() {
+	l.choice = lzmaProbInit
+	l.choice2 = lzmaProbInit
+	for i := range l.low {
+		initProbs(l.low[i][:])
+		initProbs(l.mid[i][:])
+	}
+	initProbs(l.high[:])
+}
+
+func (l *lzmaLenDecoder) decode(d *lzmaRangeDecoder, posState uint32) uint32 {
+	if d.bit(&l.choice) == 0 {
+		return d.tree(l.low[posState][:], 3)
+	}
+	if d.bit(&l.choice2) == 0 {
+		return 8 + d.tree(l.mid[posState][:], 3)
+	}
+	return 16 + d.tree(l.high[:], 8)
+}
+
+func initProbs(p []uint16) {
+	for i := range p {
+		p[i] = lzmaProbInit
+	}
+}
+
+func lzmaDecompress(in []byte, out []byte) error {
+	var d lzmaRangeDecoder
+	var isMatch, isRep0Long [lzmaStates << lzmaPB]uint16
+	var isRep, isRepG0, isRepG1, isRepG2 [lzmaStates]uint16
+	var posSlot [4][1 << 6]uint16
+	var posDecoders [1 + 1 + 128 - 14]uint16		// the SDK indexes this from one before its start; we index from one after
+	var align [1 << 4]uint16
+	var lenDecoder, repLenDecoder lzmaLenDecoder
+	literal := make([]uint16, 0x300 << (lzmaLC + lzmaLP))
+
+	initProbs(isMatch[:])
+	initProbs(isRep0Long[:])
+	initProbs(isRep[:])
+	initProbs(isRepG0[:])
+	initProbs(isRepG1[:])
+	initProbs(isRepG2[:])
+	for i := range posSlot {
+		initProbs(posSlot[i][:])
+	}
+	initProbs(posDecoders[:])
+	initProbs(align[:])
+	initProbs(literal)
+	lenDecoder.init()
+	repLenDecoder.init()
+
+	err := d.init(in)
+	if err != nil {
+		return err
+	}
+
+	state := uint32(0)
+	var rep0, rep1, rep2, rep3 uint32
+	pos := 0
+	for pos < len(out) {
+		posState := uint32(pos) & (1 << lzmaPB - 1)
+		if d.bit(&isMatch[state << lzmaPB + posState]) == 0 {
+			prev := uint32(0)
+			if pos > 0 {
+				prev = uint32(out[pos - 1])
+			}
+			p := literal[0x300 * ((uint32(pos) & (1 << lzmaLP - 1)) << lzmaLC + prev >> (8 - lzmaLC)):]
+			sym := uint32(1)
+			if state >= 7 {
+				match := uint32(out[pos - int(rep0) - 1])
+				for sym < 0x100 {
+					mbit := (match >> 7) & 1
+					match <<= 1
+					b := d.bit(&p[(1 + mbit) << 8 + sym])
+					sym = sym << 1 | b
+					if mbit != b {
+						break
+					}
+				}
+			}
+			for sym < 0x100 {
+				sym = sym << 1 | d.bit(&p[sym])
+			}
+			out[pos] = byte(sym)
+			pos++
+			switch {
+			case state < 4:
+				state = 0
+			case state < 10:
+				state -= 3
+			default:
+				state -= 6
+			}
+			continue
+		}
+
+		var length uint32
+		if d.bit(&isRep[state]) != 0 {
+			if pos == 0 {
+				return fmt.Errorf("LZMA repeat before any data")
+			}
+			if d.bit(&isRepG0[state]) == 0 {
+				if d.bit(&isRep0Long[state << lzmaPB + posState]) == 0 {
+					if state < 7 {
+						state = 9
+					} else {
+						state = 11
+					}
+					out[pos] = out[pos - int(rep0) - 1]
+					pos++
+					continue
+				}
+			} else {
+				var dist uint32
+				if d.bit(&isRepG1[state]) == 0 {
+					dist = rep1
+				} else {
+					if d.bit(&isRepG2[state]) == 0 {
+						dist = rep2
+					} else {
+						dist = rep3
+						rep3 = rep2
+					}
+					rep2 = rep1
+				}
+				rep1 = rep0
+				rep0 = dist
+			}
+			length = repLenDecoder.decode(&d, posState)
+			if state < 7 {
+				state = 8
+			} else {
+				state = 11
+			}
+		} else {
+			rep3, rep2, rep1 = rep2, rep1, rep0
+			length = lenDecoder.decode(&d, posState)
+			if state < 7 {
+				state = 7
+			} else {
+				state = 10
+			}
+			lenState := length
+			if lenState > 3 {
+				lenState = 3
+			}
+			slot := d.tree(posSlot[lenState][:], 6)
+			if slot < 4 {
+				rep0 = slot
+			} else {
+				ndirect := uint(slot >> 1) - 1
+				rep0 = (2 | slot & 1) << ndirect
+				if slot < 14 {
+					rep0 += d.reverseTree(posDecoders[rep0 - slot:], ndirect)
+				} else {
+					rep0 += d.direct(ndirect - 4) << 4
+					rep0 += d.reverseTree(align[:], 4)
+					if rep0 == 0xFFFFFFFF {		// end marker
+						break
+					}
+				}
+			}
+		}
+		length += 2
+		if int(rep0) >= pos {
+			return fmt.Errorf("LZMA distance %d out of range at %d", rep0 + 1, pos)
+		}
+		for ; length > 0 && pos < len(out); length-- {
+			out[pos] = out[pos - int(rep0) - 1]
+			pos++
+		}
+	}
+	if d.overrun {
+		return fmt.Errorf("LZMA data truncated")
+	}
+	if pos != len(out) {
+		return fmt.Errorf("LZMA data ended early (%d of %d bytes)", pos, len(out))
+	}
+	return nil
+}
@@ -4,15 +4,37 @@ package main
 import (
 	"fmt"
 	"os"
+	"flag"
+	"sort"
+	"strings"
+	"encoding/hex"
 	"code.google.com/p/rsc/fuse"
 	"log"
 )
 
 func (g *Game) Find() (found bool, err error) {
-	// did we find this already?
-	if g.Found {
-		return true, nil
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	// did we look for this already? the answer won't change while we're mounted, and every stat asks
+	if g.checked {
+		return g.Found, nil
 	}
+	g.resetAudit()
+	defer func() {
+		if err != nil {
+			g.problem("%v", err)
+		} else {		// errors might not happen again, so those sets get another try
+			g.checked = true
+		}
+		g.auditBIOS()
+		if !found {
+			log.Printf("game %s not found: %s\n", g.Name, g.auditSummary())
+			// the ROMs may have been found before something else wasn't; don't serve them
+			g.ROMLoc = ""
+			g.Extras = nil
+			g.Synth = nil
+		}
+	}()
 	found, err = g.findROMs()
 	if err != nil {
 		log.Printf("error finding ROMs for game %s: %v\n", g.Name, err)
@@ -27,46 +49,226 @@ func (g *Game) Find() (found bool, err error) {
 	} else if !found {
 		return
 	}
+	found, err = g.findDevices()
+	if err != nil {
+		log.Printf("error finding devices for game %s: %v\n", g.Name, err)
+		return
+	} else if !found {
+		return
+	}
 	g.Found = true
 	return
 }
 
+var cachePath = flag.String("cache", "", "remember verification results in this file across mounts")
+var sampleDirList = flag.String("samples", "", "file listing the directories to look for sample sets in, like dirlistfile")
+var hashDir = flag.String("hash", "", "MAME hash directory; mount the software lists in it too")
+var rawView = flag.Bool("raw", false, "also show the contents of each CHD as a disk image under raw/")
+var normalize = flag.String("normalize", "", "comma-separated ways names may differ from the XML and still match: case, nfc (Unicode normalization), prefix (directories on archive entry names), or all")
+var useIndex = flag.Bool("index", false, "index every archive and CHD in the ROM directories at startup so ROMs and CHDs can be found by content (ROMs found that way are served in a synthesized zip)")
+
+func init() {
+	flag.StringVar(&sevenzipPath, "7z", sevenzipPath, "7-Zip executable used to read .7z sets")
+	flag.StringVar(&catalogIndex, "catalogindex", catalogIndex, "where the compiled catalog goes (default mamexml.mfidx); used, and kept up to date, whenever it exists")
+	flag.StringVar(&xzPath, "xz", xzPath, "xz executable used to read xz-compressed catalogs")
+	flag.StringVar(&zstdPath, "zstd", zstdPath, "zstd executable used to read zstd-compressed catalogs")
+	flag.BoolVar(&synthesize, "synthesize", synthesize, "serve a synthesized zip for games whose ROMs are spread across several archives")
+	flag.StringVar(&setMode, "setmode", setMode, "how ROM sets are stored: split, merged, nonmerged, or auto to try each")
+	flag.StringVar(&chdCheck, "chdcheck", chdCheck, "how CHDs are checked when mounting: header (trust the SHA-1 in the header) or deep (decode and hash the whole thing)")
+	flag.StringVar(&extrasPolicy, "extras", extrasPolicy, "what to do about unexpected files in archives: strict (the set is bad), ignore, or filter (serve a synthesized zip without them)")
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "usage: %s [options] mamexml dirlistfile mountpoint\n", os.Args[0])
+	fmt.Fprintf(os.Stderr, "       %s [options] report mamexml dirlistfile [game or list/software ...]\n", os.Args[0])
+	fmt.Fprintf(os.Stderr, "       %s [options] scrub mamexml dirlistfile [game or list/software ...]\n", os.Args[0])
+	fmt.Fprintf(os.Stderr, "       %s [options] chdinfo mamexml dirlistfile [game or list/software ...]\n", os.Args[0])
+	fmt.Fprintf(os.Stderr, "       %s [options] compile mamexml\n", os.Args[0])
+	fmt.Fprintf(os.Stderr, "mamexml is MAME's -listxml output, a software list, a Logiqx XML or ClrMamePro DAT file (any of them gzipped, xz, zstd, or alone in a zip), or MAME itself to run -listxml\n")
+	flag.PrintDefaults()
+	os.Exit(1)
+}
+
 func main() {
-	if len(os.Args) != 4 {
-		fmt.Fprintf(os.Stderr, "usage: %s mamexml dirlistfile mountpoint\n", os.Args[0])
-		os.Exit(1)
+	flag.Usage = usage
+	flag.Parse()
+	switch setMode {
+	case splitSets, mergedSets, nonMergedSets, autoSets:
+	default:
+		fmt.Fprintf(os.Stderr, "unknown set mode %q\n", setMode)
+		usage()
+	}
+	switch extrasPolicy {
+	case strictExtras, ignoreExtras, filterExtras:
+	default:
+		fmt.Fprintf(os.Stderr, "unknown extras policy %q\n", extrasPolicy)
+		usage()
 	}
-	fstree := getGames(os.Args[1])
-	getDirList(os.Args[2])
-	mount, err := fuse.Mount(os.Args[3])
+	if err := parseNormalizations(*normalize); err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		usage()
+	}
+	switch chdCheck {
+	case headerCHDCheck, deepCHDCheck:
+	default:
+		fmt.Fprintf(os.Stderr, "unknown CHD check %q\n", chdCheck)
+		usage()
+	}
+	if *cachePath != "" {
+		loadCache(*cachePath)
+		go cacheFlusher()
+	}
+	if flag.Arg(0) == "report" {
+		report(flag.Args()[1:])
+		return
+	}
+	if flag.Arg(0) == "scrub" {
+		scrub(flag.Args()[1:])
+		return
+	}
+	if flag.Arg(0) == "chdinfo" {
+		chdinfo(flag.Args()[1:])
+		return
+	}
+	if flag.Arg(0) == "compile" {
+		compile(flag.Args()[1:])
+		return
+	}
+	if flag.NArg() != 3 {
+		usage()
+	}
+	fstree := load(flag.Arg(0), flag.Arg(1))
+	mount, err := fuse.Mount(flag.Arg(2))
 	if err != nil {
 		log.Fatalf("error launching FUSE file system: %v", err)
 	}
 fmt.Println("starting server")
 	mount.Serve(fstree)
+	saveCache()
 }
 
-func x() {
-	if len(os.Args) != 4 {
-		fmt.Fprintf(os.Stderr, "usage: %s mamexml dirlistfile mountpoint\n", os.Args[0])
-		os.Exit(1)
-	}
-	getGames(os.Args[1])
-	getDirList(os.Args[2])
-//	startServer()
-	for _, g := range games {
-		fmt.Printf("%12s ", g.Name)
-		if g.Found {			// already found (parent)
-			fmt.Println(g.ROMLoc)
+func load(mamexml string, dirlistfile string) *fuse.Tree {
+	getDirList(dirlistfile)		// first, so the tree can show which kind of archive each set is in
+	fstree := getGames(mamexml)
+	if *hashDir != "" {
+		getSoftwareLists(*hashDir, fstree)
+	}
+	if *sampleDirList != "" {
+		getSampleDirList(*sampleDirList)
+	}
+	if *useIndex {
+		buildROMIndex()
+		chdIndexOnce.Do(buildCHDIndex)
+	}
+	return fstree
+}
+
+// audit the given games (or all of them) and print the results instead of mounting anything
+func report(args []string) {
+	if len(args) < 2 {
+		usage()
+	}
+	load(args[0], args[1])
+	names := args[2:]
+	if len(names) == 0 {
+		for name := range games {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+	}
+	for _, name := range names {
+		g := findGame(name)
+		if g == nil {
+			fmt.Printf("%s: no such game\n", name)
 			continue
 		}
-		found, err := g.Find()
-		if err != nil {
-			fmt.Printf("error: %v\n", err)
-		} else if !found {
-			fmt.Println("not found")
-		} else {
-			fmt.Println(g.ROMLoc)
+		g.Find()
+		g.writeReport(os.Stdout)
+	}
+	saveCache()
+}
+
+// decode every CHD of the given games (or all of them) and report any damage
+func scrub(args []string) {
+	if len(args) < 2 {
+		usage()
+	}
+	load(args[0], args[1])
+	for _, name := range gamesWithCHDs(args[2:]) {
+		g := findGame(name)
+		if g == nil {
+			fmt.Printf("%s: no such game\n", name)
+			continue
+		}
+		for i := range g.CHDs {
+			chd := &g.CHDs[i]
+			if chd.Status == nodump {
+				continue
+			}
+			files := g.chdFiles(chd)
+			if len(files) == 0 {
+				fmt.Printf("%s: disk %s: not found\n", name, chd.Name)
+				continue
+			}
+			for _, fn := range files {
+				v, err := g.scrubCHD(fn)
+				if err != nil {
+					fmt.Printf("%s: disk %s: %s: could not check: %v\n", name, chd.Name, fn, err)
+					continue
+				}
+				cacheDeepResult(fn, v)
+				result := v.String()
+				if chd.SHA1 != "" && hex.EncodeToString(v.SHA1[:]) != strings.ToLower(chd.SHA1) {
+					result += "; SHA-1 doesn't match the MAME XML file"
+				}
+				fmt.Printf("%s: disk %s: %s: %s\n", name, chd.Name, fn, result)
+			}
+		}
+	}
+	saveCache()
+}
+
+// the given games, or all of them that have CHDs if none are given
+func gamesWithCHDs(names []string) []string {
+	if len(names) != 0 {
+		return names
+	}
+	for name, g := range games {
+		if len(g.CHDs) != 0 {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// print what's in the header and metadata of each CHD of the given games (or all of them)
+func chdinfo(args []string) {
+	if len(args) < 2 {
+		usage()
+	}
+	load(args[0], args[1])
+	for _, name := range gamesWithCHDs(args[2:]) {
+		g := findGame(name)
+		if g == nil {
+			fmt.Printf("%s: no such game\n", name)
+			continue
+		}
+		for i := range g.CHDs {
+			chd := &g.CHDs[i]
+			files := g.chdFiles(chd)
+			if len(files) == 0 {
+				fmt.Printf("%s: disk %s: not found\n", name, chd.Name)
+				continue
+			}
+			for _, fn := range files {
+				info, err := chdInfo(fn)
+				if err != nil {
+					fmt.Printf("%s: disk %s: %s: %v\n", name, chd.Name, fn, err)
+					continue
+				}
+				fmt.Printf("%s: disk %s:\n%s\n", name, chd.Name, info)
+			}
 		}
 	}
 }
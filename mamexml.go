@@ -2,92 +2,208 @@
 package main
 
 import (
-	"os"
-	"encoding/xml"
 	"strings"
-	"io"
+	"path/filepath"
+	"sync"
 	"code.google.com/p/rsc/fuse"
 	"log"
 )
 
 const nodump = "nodump"	// for ROM.Status
+const baddump = "baddump"
+const yes = "yes"		// for ROM.Optional and the like
 
 type ROM struct {
 	Name	string		`xml:"name,attr"`
-	Size		uint32		`xml:"size,attr"`		// uint32 because that's what archive/zip.FIleHeader.UncompressedSize is
+	Size		uint64		`xml:"size,attr"`
 	CRC32	string		`xml:"crc,attr"`
 	SHA1	string		`xml:"sha1,attr"`
 	Status	string		`xml:"status,attr"`
+	Merge	string		`xml:"merge,attr"`		// name of the same ROM in the parent
+	Optional	string		`xml:"optional,attr"`
+	BIOS	string		`xml:"bios,attr"`		// the BIOS option this ROM is part of, if any
+
+	Audit	ItemAudit		`xml:"-"`		// prepared by Game.Find()
+	otherBIOS	bool			// prepared by Catalog.prepare(); part of a BIOS option other than the default
+}
+
+// only the default BIOS is required; the others are nice to have
+func (r *ROM) isOptional() bool {
+	return r.Optional == yes || r.otherBIOS
 }
 
 type CHD struct {
 	Name	string		`xml:"name,attr"`
 	SHA1	string		`xml:"sha1,attr"`
+	MD5		string		`xml:"md5,attr"`		// all that XML files from before CHDs had SHA-1s give
 	Status	string		`xml:"status,attr"`
+	Merge	string		`xml:"merge,attr"`
+	Optional	string		`xml:"optional,attr"`
+
+	Audit	ItemAudit		`xml:"-"`		// prepared by Game.Find()
+}
+
+type BIOSSet struct {
+	Name		string	`xml:"name,attr"`
+	Description	string	`xml:"description,attr"`
+	Default		string	`xml:"default,attr"`
+}
+
+type DeviceRef struct {
+	Name	string		`xml:"name,attr"`
 }
 
 type Game struct {
 	Name	string	`xml:"name,attr"`
 	CloneOf	string	`xml:"cloneof,attr"`
 	ROMOf	string	`xml:"romof,attr"`
-	// TODO do I need sampleof?
+	SampleOf	string	`xml:"sampleof,attr"`
 	ROMs	[]ROM	`xml:"rom"`
 	CHDs	[]CHD	`xml:"disk"`
+	IsDevice	string	`xml:"isdevice,attr"`
+	IsBIOS	string	`xml:"isbios,attr"`
+	Runnable	string	`xml:"runnable,attr"`		// see listxml.go
+	BIOSSets	[]BIOSSet	`xml:"biosset"`
+	DeviceRefs	[]DeviceRef	`xml:"device_ref"`
+	Samples	[]Sample	`xml:"sample"`
 
-	// prepared by getGames()
+	// prepared by Catalog.prepare()
 	Parents	[]string			`xml:"-"`		// [CloneOf, ROMOf] but only if either is specified and no repeats; avoids code duplication in check.go
+	Clones	[]string			`xml:"-"`		// games whose CloneOf is this one; merged sets keep them in our archive
 
 	// prepared by Game.Find()
+	lock		sync.Mutex		// FUSE requests come in concurrently, and each of them can Find() the set
+	checked	bool				// Find() has already looked, whether or not it found the set
 	Found	bool				`xml:"-"`
 	ROMLoc	string			`xml:"-"`
+	Synth	*synthZip			`xml:"-"`		// if no one archive has everything (or we're filtering out extras)
+	Extras	[]string			`xml:"-"`		// files in the archive at ROMLoc that aren't part of the set
+	Audit	*Audit			`xml:"-"`
 	CHDLoc	map[string]string	`xml:"-"`
+
+	cat		*Catalog			// the catalog it came from
+	folder	string			// the <dir>s of a Logiqx DAT it was in, which RomVault keeps it in folders of
 }
 
-var games = map[string]*Game{}
+// a set of games loaded from one XML file
+type Catalog struct {
+	Games		map[string]*Game
+	Dir			string		// subdirectory of each ROM directory the sets live in
+	MergeInfo	bool			// if the XML file doesn't use merge attributes at all, we have to assume a clone's ROMs with the same name as its parent's come from the parent
+}
 
-func getGames(filename string) *fuse.Tree {
-	f, err := os.Open(filename)
+// the main MAME XML file
+var machines = &Catalog{
+	Games:	map[string]*Game{},
+}
+var games = machines.Games
+
+// a parent, device, or anything else g refers to by name
+func (g *Game) lookup(name string) *Game {
+	return g.cat.Games[name]
+}
+
+// where the set is kept under each rompath: in its software list's directory, and in the folders of any <dir>s a Logiqx DAT had it in
+func (g *Game) diskDir() string {
+	return filepath.Join(g.cat.Dir, g.folder)
+}
+
+// the same, with the set's own name on the end, for the set with the given name (a parent, usually), which may not be in the catalog
+func (g *Game) diskPath(name string) string {
+	if a := g.lookup(name); a != nil {
+		return filepath.Join(a.diskDir(), name)
+	}
+	return filepath.Join(g.cat.Dir, name)
+}
+
+// machines that use a BIOS list its options themselves, but DAT files made from the XML don't always, so look in the BIOS itself too
+func (g *Game) biosSets() []BIOSSet {
+	for a := g; a != nil; a = g.lookup(a.ROMOf) {
+		if len(a.BIOSSets) != 0 {
+			return a.BIOSSets
+		}
+		if a.ROMOf == "" || a.ROMOf == a.Name {
+			break
+		}
+	}
+	return nil
+}
+
+// the one marked default, or the first if none are
+func (g *Game) defaultBIOS() string {
+	sets := g.biosSets()
+	for _, b := range sets {
+		if b.Default == yes {
+			return b.Name
+		}
+	}
+	if len(sets) != 0 {
+		return sets[0].Name
+	}
+	return ""
+}
+
+// lets findScattered() look for a ROM in the sets of other games that share it
+var romsBySHA1 = map[string][]*Game{}
+
+// filename is either the XML file or a MAME executable to get it from
+func readCatalogFile(filename string, fstree *fuse.Tree) {
+	f, err := openListXML(filename)
 	if err != nil {
 		log.Fatalf("could not open MAME XML file %s: %v", filename, err)
 	}
-	defer f.Close()
+	readCatalog(f, filename, fstree)
+	err = f.Close()
+	if err != nil {
+		log.Fatalf("error getting MAME XML from %s: %v", filename, err)
+	}
+}
 
-	mamexml := xml.NewDecoder(f)
+func getGames(filename string) *fuse.Tree {
 	fstree := new(fuse.Tree)
+	loadCatalog(filename, fstree)
+	machines.prepare(fstree)
 
-	// skip to the first game
-findFirst:
-	for {
-		t, err := mamexml.Token()
-		if err != nil {
-			log.Fatalf("error finding first game in MAME XML file %s: %v", filename, err)
+	makeSampleSets()
+	addSamplesToTree(fstree)
+
+	return fstree
+}
+
+// fill in everything we work out once the whole catalog is loaded, and add it all to the tree
+func (c *Catalog) prepare(fstree *fuse.Tree) {
+	for _, g := range c.Games {
+		g.cat = c
+		if g.CloneOf != "" {
+			g.Parents = append(g.Parents, g.CloneOf)
 		}
-		switch e := t.(type) {
-		case xml.StartElement:
-			if strings.ToLower(e.Name.Local) == "mame" {
-				break findFirst
-			}
+		if g.ROMOf != "" && g.ROMOf != g.CloneOf {
+			g.Parents = append(g.Parents, g.ROMOf)
 		}
 	}
 
-	// now read everything
-	for {
-		this := new(Game)
-		err = mamexml.Decode(this)
-		if err == io.EOF {
-			break
-		} else if err != nil {
-			log.Fatalf("error reading game from MAME XML file %s: %v", filename, err)
+	for _, g := range c.Games {
+		def := g.defaultBIOS()
+		for i := range g.ROMs {
+			g.ROMs[i].otherBIOS = g.ROMs[i].BIOS != "" && g.ROMs[i].BIOS != def
 		}
-		games[this.Name] = this
-		if this.CloneOf != "" {
-			this.Parents = append(this.Parents, this.CloneOf)
+		if p, ok := c.Games[g.CloneOf]; ok {
+			p.Clones = append(p.Clones, g.Name)
 		}
-		if this.ROMOf != "" && this.ROMOf != this.CloneOf {
-			this.Parents = append(this.Parents, this.ROMOf)
+		for _, chd := range g.CHDs {
+			if chd.Merge != "" {
+				c.MergeInfo = true
+			}
+		}
+		for _, r := range g.ROMs {
+			if r.Merge != "" {
+				c.MergeInfo = true
+			}
+			if r.SHA1 != "" {
+				sha1 := strings.ToLower(r.SHA1)
+				romsBySHA1[sha1] = append(romsBySHA1[sha1], g)
+			}
 		}
-		this.AddToTree(fstree)
+		g.AddToTree(fstree)
 	}
-
-	return fstree
 }
@@ -0,0 +1,130 @@
+// 18 october 2026
+package main
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sort"
+	"sync"
+	"time"
+	"golang.org/x/text/unicode/norm"
+)
+
+// the XML's names are exact, but sets made with other tools don't always agree: Windows and macOS tools change case, macOS stores names decomposed (NFD), and some zippers keep the directory a file came from
+// these let a name match if it's the same once normalized; exact matches are always tried first
+const (
+	normCase		= "case"		// ignore case
+	normNFC		= "nfc"		// compare Unicode names in composed form
+	normPrefix	= "prefix"		// ignore any directories on the front of archive entry names
+)
+
+var normalizations = map[string]bool{}
+
+// from the -normalize flag: a comma-separated list of the above, or all
+func parseNormalizations(s string) error {
+	if s == "" {
+		return nil
+	}
+	for _, n := range strings.Split(s, ",") {
+		switch n = strings.TrimSpace(n); n {
+		case normCase, normNFC, normPrefix:
+			normalizations[n] = true
+		case "all":
+			normalizations[normCase] = true
+			normalizations[normNFC] = true
+			normalizations[normPrefix] = true
+		default:
+			return fmt.Errorf("unknown name normalization %q", n)
+		}
+	}
+	return nil
+}
+
+func normalizeName(name string) string {
+	if normalizations[normPrefix] {
+		name = path.Base(strings.Replace(name, "\\", "/", -1))
+	}
+	if normalizations[normNFC] {
+		name = norm.NFC.String(name)
+	}
+	if normalizations[normCase] {
+		name = strings.ToLower(name)
+	}
+	return name
+}
+
+// directory listings by normalized name, kept until the directory changes
+type normDir struct {
+	mtime	time.Time
+	names	map[string]string		// normalized name -> name on disk
+}
+
+var normDirs = map[string]*normDir{}
+var normDirsLock sync.Mutex
+
+// the name of the file in dir that normalizes to the same thing as name, or name itself if there isn't one
+func dirEntryNamed(dir string, name string) string {
+	if _, err := os.Lstat(filepath.Join(dir, name)); err == nil {
+		return name
+	}
+	fi, err := os.Stat(dir)
+	if err != nil {
+		return name
+	}
+	normDirsLock.Lock()
+	defer normDirsLock.Unlock()
+	nd, ok := normDirs[dir]
+	if !ok || !nd.mtime.Equal(fi.ModTime()) {
+		d, err := os.Open(dir)
+		if err != nil {
+			return name
+		}
+		entries, err := d.Readdirnames(-1)
+		d.Close()
+		if err != nil {
+			return name
+		}
+		sort.Strings(entries)		// so if two names clash the same one always wins
+		nd = &normDir{
+			mtime:	fi.ModTime(),
+			names:	map[string]string{},
+		}
+		for _, e := range entries {
+			n := normalizeName(e)
+			if _, ok := nd.names[n]; !ok {
+				nd.names[n] = e
+			}
+		}
+		normDirs[dir] = nd
+	}
+	if e, ok := nd.names[normalizeName(name)]; ok {
+		return e
+	}
+	return name
+}
+
+// find root/rel, allowing for the normalizations at each level below root
+func normalizedPath(root string, rel string) string {
+	p := filepath.Join(root, rel)
+	if len(normalizations) == 0 {
+		return p
+	}
+	if _, err := os.Lstat(p); err == nil {
+		return p
+	}
+	p = root
+	for _, part := range strings.Split(filepath.ToSlash(filepath.Clean(rel)), "/") {
+		p = filepath.Join(p, dirEntryNamed(p, part))
+	}
+	return p
+}
+
+// the audit says when a file only matched once normalized, so the set can be fixed
+func normalizedNote(root string, got string, want string) string {
+	got, _ = filepath.Rel(root, got)
+	want, _ = filepath.Rel(root, want)
+	return fmt.Sprintf("%s for %s", got, want)
+}
@@ -0,0 +1,296 @@
+// 18 october 2026
+package main
+
+import (
+	"fmt"
+	"io"
+	"sync"
+	"sort"
+	"strings"
+	"strconv"
+	"encoding/binary"
+	"container/list"
+)
+
+// the raw view shows what's inside each CHD as an ordinary disk image, decoded as it's read, so the usual tools can look inside without chdman
+// hard disks become .img and DVDs .iso, both just the CHD's data; CDs become .bin, with each track's sectors in the size its type says (subcode and CHD's padding between tracks dropped) and audio in the little-endian order everything else expects
+// TODO a .cue to go with the .bin; GD-ROMs are handled like CDs, which gives an image nothing can read
+
+var rawImageExts = []string{".bin", ".iso", ".img"}
+
+// how many decoded hunks each open image keeps around
+const rawCacheHunks = 64
+
+type hunkCache struct {
+	c		*chdFile
+	lru		*list.List		// of *cachedHunk, most recently used first
+	hunks	map[uint32]*list.Element
+}
+
+type cachedHunk struct {
+	n		uint32
+	data		[]byte
+}
+
+func newHunkCache(c *chdFile) *hunkCache {
+	return &hunkCache{
+		c:		c,
+		lru:		list.New(),
+		hunks:	map[uint32]*list.Element{},
+	}
+}
+
+func (h *hunkCache) get(n uint32) ([]byte, error) {
+	if e, ok := h.hunks[n]; ok {
+		h.lru.MoveToFront(e)
+		return e.Value.(*cachedHunk).data, nil
+	}
+	var data []byte
+	if h.lru.Len() >= rawCacheHunks {		// reuse the oldest one's buffer
+		e := h.lru.Back()
+		ch := h.lru.Remove(e).(*cachedHunk)
+		delete(h.hunks, ch.n)
+		data = ch.data
+	} else {
+		data = make([]byte, h.c.hdr.HunkBytes)
+	}
+	err := h.c.readHunk(n, data)
+	if err != nil {
+		return nil, err
+	}
+	h.hunks[n] = h.lru.PushFront(&cachedHunk{
+		n:		n,
+		data:	data,
+	})
+	return data, nil
+}
+
+// read the CHD's logical data through the cache
+func (h *hunkCache) readAt(p []byte, off uint64) error {
+	hb := uint64(h.c.hdr.HunkBytes)
+	for len(p) > 0 {
+		data, err := h.get(uint32(off / hb))
+		if err != nil {
+			return err
+		}
+		k := copy(p, data[off % hb:])
+		p = p[k:]
+		off += uint64(k)
+	}
+	return nil
+}
+
+// a run of CD frames in the CHD that make up a track in the image
+type rawTrack struct {
+	offset	int64		// in the image
+	frame	uint64		// first frame in the CHD
+	frames	uint64
+	datasize	int			// bytes of each frame that go in the image
+	audio	bool
+}
+
+type rawImage struct {
+	lock		sync.Mutex
+	c		*chdFile
+	cache	*hunkCache
+	ext		string
+	size		int64
+	tracks	[]rawTrack		// nil if the image is just the CHD's data
+}
+
+// sector sizes of the CD track types
+var cdTrackDataSize = map[string]int{
+	"MODE1":			2048,
+	"MODE1_RAW":		2352,
+	"MODE2":			2336,
+	"MODE2_FORM1":	2048,
+	"MODE2_FORM2":	2324,
+	"MODE2_FORM_MIX":	2336,
+	"MODE2_RAW":		2352,
+	"AUDIO":			2352,
+}
+
+// CD metadata is text like "TRACK:1 TYPE:MODE1_RAW SUBTYPE:NONE FRAMES:1234"
+func parseMetadataFields(data []byte) map[string]string {
+	fields := map[string]string{}
+	for _, f := range strings.Fields(strings.TrimRight(string(data), "\x00")) {
+		if k, v, ok := strings.Cut(f, ":"); ok {
+			fields[k] = v
+		}
+	}
+	return fields
+}
+
+// the track types in CHCD metadata, in order
+var chcdTrackTypes = []string{"MODE1", "MODE1_RAW", "MODE2", "MODE2_FORM1", "MODE2_FORM2", "MODE2_FORM_MIX", "MODE2_RAW", "AUDIO"}
+
+const chcdMaxTracks = 99
+
+// v3 and v4 CD CHDs have the whole table of contents in one binary CHCD item instead: the number of tracks, then for each of 99 tracks its type, subtype, data size, subcode size, frames, and padding frames, all 32-bit
+// like MAME, we don't know which byte order it'll be in, so if the number of tracks is too big it's the other one
+// this turns it into the same fields as the text metadata
+func parseCHCD(data []byte) ([]map[string]string, error) {
+	if len(data) != 4 + chcdMaxTracks * 6 * 4 {
+		return nil, fmt.Errorf("CHCD metadata is %d bytes long", len(data))
+	}
+	var order binary.ByteOrder = binary.BigEndian
+	n := order.Uint32(data)
+	if n > chcdMaxTracks {
+		order = binary.LittleEndian
+		n = order.Uint32(data)
+	}
+	if n > chcdMaxTracks {
+		return nil, fmt.Errorf("CHCD metadata has %d tracks", n)
+	}
+	var tracks []map[string]string
+	for i := 0; i < int(n); i++ {
+		t := data[4 + i * 6 * 4:]
+		typ := order.Uint32(t[0:])
+		if typ >= uint32(len(chcdTrackTypes)) {
+			return nil, fmt.Errorf("unknown track type %d in CHCD metadata", typ)
+		}
+		tracks = append(tracks, map[string]string{
+			"TRACK":		strconv.Itoa(i + 1),
+			"TYPE":		chcdTrackTypes[typ],
+			"FRAMES":	strconv.FormatUint(uint64(order.Uint32(t[16:])), 10),
+			"PAD":		strconv.FormatUint(uint64(order.Uint32(t[20:])), 10),
+		})
+	}
+	return tracks, nil
+}
+
+// work out what kind of disk the CHD is from its metadata; takes ownership of c
+func newRawImage(c *chdFile) (*rawImage, error) {
+	md, err := c.metadata()
+	if err != nil {
+		return nil, err
+	}
+	img := &rawImage{
+		c:		c,
+		cache:	newHunkCache(c),
+		size:		int64(c.hdr.LogicalBytes),
+	}
+	var tracks []map[string]string
+	for _, m := range md {
+		switch m.Tag {
+		case fourcc("GDDD"):
+			img.ext = ".img"
+		case fourcc("DVD "):
+			img.ext = ".iso"
+		case fourcc("CHTR"), fourcc("CHT2"), fourcc("CHGT"), fourcc("CHGD"):
+			img.ext = ".bin"
+			tracks = append(tracks, parseMetadataFields(m.Data))
+		case fourcc("CHCD"):
+			img.ext = ".bin"
+			t, err := parseCHCD(m.Data)
+			if err != nil {
+				return nil, err
+			}
+			tracks = append(tracks, t...)
+		}
+	}
+	sort.SliceStable(tracks, func(i, j int) bool {
+		a, _ := strconv.Atoi(tracks[i]["TRACK"])
+		b, _ := strconv.Atoi(tracks[j]["TRACK"])
+		return a < b
+	})
+	var frame uint64
+	for _, fields := range tracks {
+		frames, err := strconv.ParseUint(fields["FRAMES"], 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("bad track metadata %v", fields)
+		}
+		datasize, ok := cdTrackDataSize[fields["TYPE"]]
+		if !ok {
+			return nil, fmt.Errorf("unknown track type %q", fields["TYPE"])
+		}
+		t := rawTrack{
+			frame:	frame,
+			frames:	frames,
+			datasize:	datasize,
+			audio:	fields["TYPE"] == "AUDIO",
+		}
+		if len(img.tracks) != 0 {
+			last := img.tracks[len(img.tracks) - 1]
+			t.offset = last.offset + int64(last.frames) * int64(last.datasize)
+		}
+		img.tracks = append(img.tracks, t)
+		// each track is padded to a multiple of four frames, unless the metadata says otherwise (GD-ROMs)
+		pad := (4 - frames % 4) % 4
+		if p, ok := fields["PAD"]; ok {
+			pad, _ = strconv.ParseUint(p, 10, 32)
+		}
+		frame += frames + pad
+	}
+	switch {
+	case img.ext == "":
+		return nil, fmt.Errorf("not a hard disk, CD, or DVD")
+	case img.ext == ".bin" && c.hdr.HunkBytes % cdFrameSize != 0:
+		return nil, fmt.Errorf("CD CHD hunks aren't a whole number of frames")
+	case img.tracks != nil:
+		last := img.tracks[len(img.tracks) - 1]
+		img.size = last.offset + int64(last.frames) * int64(last.datasize)
+	}
+	return img, nil
+}
+
+func (img *rawImage) ReadAt(p []byte, off int64) (int, error) {
+	img.lock.Lock()
+	defer img.lock.Unlock()
+	if off >= img.size {
+		return 0, io.EOF
+	}
+	want := p
+	if int64(len(want)) > img.size - off {
+		want = want[:img.size - off]
+	}
+	var err error
+	if img.tracks == nil {
+		err = img.cache.readAt(want, uint64(off))
+	} else {
+		err = img.readCD(want, off)
+	}
+	if err != nil {
+		return 0, err
+	}
+	if len(want) < len(p) {
+		return len(want), io.EOF
+	}
+	return len(want), nil
+}
+
+func (img *rawImage) readCD(p []byte, off int64) error {
+	i := len(img.tracks) - 1
+	for i > 0 && img.tracks[i].offset > off {
+		i--
+	}
+	sector := make([]byte, cdSectorData)
+	for len(p) > 0 {
+		t := &img.tracks[i]
+		end := t.offset + int64(t.frames) * int64(t.datasize)
+		if off >= end {		// on to the next track
+			i++
+			continue
+		}
+		frame := uint64(off - t.offset) / uint64(t.datasize)
+		within := int(uint64(off - t.offset) % uint64(t.datasize))
+		data := sector[:t.datasize]
+		err := img.cache.readAt(data, (t.frame + frame) * cdFrameSize)
+		if err != nil {
+			return err
+		}
+		if t.audio {		// CHD has it big-endian
+			for j := 0; j + 1 < len(data); j += 2 {
+				data[j], data[j + 1] = data[j + 1], data[j]
+			}
+		}
+		k := copy(p, data[within:])
+		p = p[k:]
+		off += int64(k)
+	}
+	return nil
+}
+
+func (img *rawImage) Close() error {
+	return img.c.Close()
+}
@@ -0,0 +1,68 @@
+// 18 october 2026
+package main
+
+import (
+	"testing"
+	"io"
+	"crypto/sha1"
+	"encoding/hex"
+	"path/filepath"
+)
+
+func rawImageSHA1(t *testing.T, file string) (*rawImage, string) {
+	c, err := openCHD(filepath.Join("testdata", file))
+	if err != nil {
+		t.Fatalf("%s: could not open: %v", file, err)
+	}
+	img, err := newRawImage(c)
+	if err != nil {
+		c.Close()
+		t.Fatalf("%s: no raw view: %v", file, err)
+	}
+	h := sha1.New()
+	_, err = io.Copy(h, io.NewSectionReader(img, 0, img.size))
+	if err != nil {
+		img.Close()
+		t.Fatalf("%s: could not read raw view: %v", file, err)
+	}
+	return img, hex.EncodeToString(h.Sum(nil))
+}
+
+// the track layout comes from binary CHCD metadata, with the padding it gives and little-endian numbers; see testdata/mkchd.py
+func TestRawCHCD(t *testing.T) {
+	img, sum := rawImageSHA1(t, "cdv3.chd")
+	defer img.Close()
+	if img.ext != ".bin" {
+		t.Errorf("raw view is %s, want .bin", img.ext)
+	}
+	if len(img.tracks) != 2 || !img.tracks[1].audio || img.tracks[1].frame != 16 {
+		t.Errorf("wrong tracks %+v", img.tracks)
+	}
+	if img.size != 10 * 2048 + 8 * 2352 {
+		t.Errorf("size %d, want %d", img.size, 10 * 2048 + 8 * 2352)
+	}
+	if want := "a44d767085aa16217d9c9c98773bd7a1617b8645"; sum != want {
+		t.Errorf("raw view SHA-1 is %s, want %s", sum, want)
+	}
+}
+
+func TestParseCHCD(t *testing.T) {
+	if _, err := parseCHCD(make([]byte, 100)); err == nil {
+		t.Errorf("short CHCD metadata accepted")
+	}
+	data := make([]byte, 4 + chcdMaxTracks * 6 * 4)
+	data[3] = 1		// one track, big-endian
+	data[4 + 3] = 7		// audio
+	data[4 + 19] = 42		// frames
+	tracks, err := parseCHCD(data)
+	if err != nil {
+		t.Fatalf("big-endian CHCD metadata: %v", err)
+	}
+	if len(tracks) != 1 || tracks[0]["TYPE"] != "AUDIO" || tracks[0]["FRAMES"] != "42" || tracks[0]["PAD"] != "0" {
+		t.Errorf("big-endian CHCD metadata gave %v", tracks)
+	}
+	data[4 + 3] = 8
+	if _, err := parseCHCD(data); err == nil {
+		t.Errorf("unknown track type accepted")
+	}
+}
@@ -0,0 +1,133 @@
+// 18 october 2026
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"code.google.com/p/rsc/fuse"
+)
+
+// samples are WAV files MAME plays for sounds it can't emulate
+// they live in sets of their own (usually shared between a parent and its clones via sampleof) in a separate samplepath, so they get their own subtree of the mount
+type Sample struct {
+	Name	string		`xml:"name,attr"`
+}
+
+type SampleSet struct {
+	Name	string
+	Samples	map[string]bool		// WAV file names (with .wav) every game using the set wants
+
+	lock		sync.Mutex
+	checked	bool			// like Game's
+	Found	bool
+	Loc		string
+	Missing	[]string			// from the best candidate we saw, for the audit
+}
+
+var sampleSets = map[string]*SampleSet{}
+
+// the name of the sample set the game uses, if any
+func (g *Game) sampleSet() string {
+	if g.SampleOf != "" {
+		return g.SampleOf
+	}
+	if len(g.Samples) != 0 {
+		return g.Name
+	}
+	return ""
+}
+
+// prepared by getGames() once all the games are loaded
+func makeSampleSets() {
+	for _, g := range games {
+		name := g.sampleSet()
+		if name == "" {
+			continue
+		}
+		s, ok := sampleSets[name]
+		if !ok {
+			s = &SampleSet{
+				Name:		name,
+				Samples:	map[string]bool{},
+			}
+			sampleSets[name] = s
+		}
+		for _, sample := range g.Samples {
+			s.Samples[sample.Name + ".wav"] = true
+		}
+	}
+}
+
+func addSamplesToTree(t *fuse.Tree) {
+	for _, s := range sampleSets {
+		for _, ext := range archiveExts {
+			if ext != "" {
+				t.Add(filepath.Join("samples", s.Name + ext), NewSampleFile(s, ext))
+			}
+		}
+		for name := range s.Samples {		// in case the set is loose
+			t.Add(filepath.Join("samples", s.Name, name), NewLooseSampleFile(s, name))
+		}
+	}
+}
+
+func (s *SampleSet) Find() (found bool, err error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.checked {
+		return s.Found, nil
+	}
+	s.Missing = nil
+	for _, d := range sampleDirs {
+		for _, ext := range archiveExts {
+			filename := filepath.Join(d, s.Name + ext)
+			missing, err := s.checkArchive(filename)
+			if err != nil {
+				return false, err
+			}
+			if missing == nil {		// not there at all
+				continue
+			}
+			if len(missing) == 0 {
+				s.checked = true
+				s.Found = true
+				s.Loc = filename
+				return true, nil
+			}
+			if s.Missing == nil || len(missing) < len(s.Missing) {
+				s.Missing = missing
+			}
+		}
+	}
+	s.checked = true
+	return false, nil
+}
+
+// samples are only checked by name; there's nothing in the XML file to check them against
+// returns the missing samples, or nil if the archive isn't there
+// extra WAVs are fine since sets are often shared by games that want different samples
+func (s *SampleSet) checkArchive(filename string) ([]string, error) {
+	a, err := openArchive(filename)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not open sample archive %s: %v", filename, err)
+	}
+	defer a.Close()
+	have := map[string]bool{}
+	for _, f := range a.Files() {
+		have[f.Name()] = true
+	}
+	missing := []string{}
+	for name := range s.Samples {
+		if !have[name] {
+			missing = append(missing, name)
+		}
+	}
+	sort.Strings(missing)
+	return missing, nil
+}
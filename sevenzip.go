@@ -0,0 +1,145 @@
+// 18 october 2026
+package main
+
+import (
+	"fmt"
+	"os"
+	"io"
+	"os/exec"
+	"bufio"
+	"bytes"
+	"strings"
+	"strconv"
+)
+
+// there's no 7z support in the standard library and the format is a mess of codecs, so we just ask 7-Zip to do the work
+// TODO solid archives get decompressed from the start for every entry we read; find a way to hash everything in one pass
+var sevenzipPath = "7z"
+
+type sevenzipArchive struct {
+	filename	string
+	fi		os.FileInfo
+	files		[]archiveFile
+}
+
+type sevenzipFile struct {
+	a		*sevenzipArchive
+	name	string
+	size		uint64
+	crc		uint32
+}
+
+func open7z(filename string) (*sevenzipArchive, error) {
+	fi, err := os.Stat(filename)
+	if err != nil {
+		return nil, err
+	}
+	out, err := exec.Command(sevenzipPath, "l", "-slt", "--", filename).Output()
+	if err != nil {
+		return nil, fmt.Errorf("could not list 7z archive with %s: %v", sevenzipPath, err)
+	}
+	a := &sevenzipArchive{
+		filename:	filename,
+		fi:		fi,
+	}
+	err = a.parseListing(out)
+	if err != nil {
+		return nil, fmt.Errorf("could not understand listing of 7z archive: %v", err)
+	}
+	return a, nil
+}
+
+// the output of 7z l -slt is a bunch of "Key = Value" blocks separated by blank lines; the ones after the ---------- line are the files
+func (a *sevenzipArchive) parseListing(out []byte) error {
+	s := bufio.NewScanner(bytes.NewReader(out))
+	for s.Scan() {
+		if s.Text() == "----------" {
+			break
+		}
+	}
+	block := map[string]string{}
+	for {
+		more := s.Scan()
+		line := s.Text()
+		if more && line != "" {
+			kv := strings.SplitN(line, " = ", 2)
+			if len(kv) == 2 {
+				block[kv[0]] = kv[1]
+			} else {		// "Key =" with an empty value drops the trailing space
+				block[strings.TrimSuffix(line, " =")] = ""
+			}
+			continue
+		}
+		if len(block) != 0 {
+			err := a.addFile(block)
+			if err != nil {
+				return err
+			}
+			block = map[string]string{}
+		}
+		if !more {
+			break
+		}
+	}
+	return s.Err()
+}
+
+func (a *sevenzipArchive) addFile(block map[string]string) error {
+	if block["Folder"] == "+" || strings.HasPrefix(block["Attributes"], "D") {
+		return nil
+	}
+	f := &sevenzipFile{
+		a:		a,
+		name:	block["Path"],
+	}
+	size, err := strconv.ParseUint(block["Size"], 10, 64)
+	if err != nil {
+		return fmt.Errorf("bad size for %s: %v", f.name, err)
+	}
+	f.size = size
+	if block["CRC"] != "" {		// empty files have no CRC
+		crc, err := strconv.ParseUint(block["CRC"], 16, 32)
+		if err != nil {
+			return fmt.Errorf("bad CRC-32 for %s: %v", f.name, err)
+		}
+		f.crc = uint32(crc)
+	}
+	a.files = append(a.files, f)
+	return nil
+}
+
+func (a *sevenzipArchive) Files() []archiveFile {
+	return a.files
+}
+
+func (a *sevenzipArchive) Close() error {
+	return nil
+}
+
+func (f *sevenzipFile) Name() string {
+	return f.name
+}
+
+func (f *sevenzipFile) Size() uint64 {
+	return f.size
+}
+
+func (f *sevenzipFile) CRC32() (uint32, error) {
+	return f.crc, nil
+}
+
+func (f *sevenzipFile) Sums() (*cacheSum, error) {
+	return cachedSums(f.a.filename, f.a.fi, f.name, int64(f.size), &f.crc, f.Open)
+}
+
+// if 7z fails partway through, reading or closing says so (see runCommand())
+func (f *sevenzipFile) Open() (io.ReadCloser, error) {
+	// -spd so names with wildcard characters in them are taken literally
+	cmd := exec.Command(sevenzipPath, "e", "-so", "-spd", "--", f.a.filename, f.name)
+	cmd.Stderr = io.Discard		// it says what it's doing there every time
+	r, err := runCommand(cmd)
+	if err != nil {
+		return nil, fmt.Errorf("could not run %s: %v", sevenzipPath, err)
+	}
+	return r, nil
+}
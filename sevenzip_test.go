@@ -0,0 +1,100 @@
+// 18 october 2026
+package main
+
+import (
+	"testing"
+	"os"
+	"path/filepath"
+)
+
+// a shell script stands in for 7-Zip: it lists testdata/7z/game.slt, which is real 7z l -slt output, and extracts rom.bin as "some ROM"
+func test7z(t *testing.T) string {
+	listing, err := filepath.Abs("testdata/7z/game.slt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	stub := writeStub(t, `case "$1" in
+l)	cat '` + listing + `' ;;
+e)	[ "$6" = rom.bin ] || exit 2
+	printf 'some ROM' ;;
+*)	exit 1 ;;
+esac
+`)
+	old := sevenzipPath
+	sevenzipPath = stub
+	t.Cleanup(func() {
+		sevenzipPath = old
+	})
+	filename := filepath.Join(t.TempDir(), "game.7z")
+	err = os.WriteFile(filename, nil, 0644)		// only stat'd
+	if err != nil {
+		t.Fatal(err)
+	}
+	return filename
+}
+
+func TestSevenzipListing(t *testing.T) {
+	a, err := open7z(test7z(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer a.Close()
+	files := a.Files()
+	if len(files) != 2 {
+		t.Fatalf("%d files, want 2 (the directory left out)", len(files))
+	}
+	for i, want := range []struct {
+		name	string
+		size		uint64
+		crc		uint32
+	}{
+		{"rom.bin",		8,	0x6f3daaa8},
+		{"sub/empty.bin",	0,	0},
+	} {
+		f := files[i]
+		crc, _ := f.CRC32()
+		if f.Name() != want.name || f.Size() != want.size || crc != want.crc {
+			t.Errorf("file %d is %s, size %d, CRC-32 %08x; want %s, size %d, CRC-32 %08x", i, f.Name(), f.Size(), crc, want.name, want.size, want.crc)
+		}
+	}
+
+	// and the SHA-1 comes from what 7z e gives
+	state, err := romState(files[0], &ROM{
+		Name:	"rom.bin",
+		Size:		8,
+		CRC32:	"6f3daaa8",
+		SHA1:	"5bbd60e7853c0ce36ddeace022b1511e8fdaf66f",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if state != stateOK {
+		t.Errorf("rom.bin is %s, want %s", stateNames[state], stateNames[stateOK])
+	}
+}
+
+// 7z failing after giving everything it should have is still a failure, and nothing gets cached
+func TestSevenzipFails(t *testing.T) {
+	testCache(t)
+	filename := test7z(t)
+	sevenzipPath = writeStub(t, "printf 'some ROM'\nexit 2\n")
+	fi, err := os.Stat(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := &sevenzipFile{
+		a:		&sevenzipArchive{
+			filename:	filename,
+			fi:		fi,
+		},
+		name:	"rom.bin",
+		size:		8,
+		crc:		0x6f3daaa8,
+	}
+	if sum, err := f.Sums(); err == nil {
+		t.Errorf("failed 7z gave sums %+v", sum)
+	}
+	if sum := cacheLookup(filename, fi, "rom.bin"); sum != nil {
+		t.Errorf("sums from failed 7z cached: %+v", sum)
+	}
+}
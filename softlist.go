@@ -0,0 +1,124 @@
+// 18 october 2026
+package main
+
+import (
+	"os"
+	"encoding/xml"
+	"path/filepath"
+	"strings"
+	"code.google.com/p/rsc/fuse"
+	"log"
+)
+
+// software lists (MAME's hash/*.xml) describe the cartridges, disks, and so on that consoles and computers load
+// each list becomes its own Catalog whose sets live in a subdirectory of each ROM directory named after the list, which is where MAME looks for them too
+// a piece of software is treated as a Game so everything in check*.go works on it unchanged
+
+type softwareList struct {
+	Name		string		`xml:"name,attr"`
+	Software	[]software	`xml:"software"`
+}
+
+type software struct {
+	Name	string			`xml:"name,attr"`
+	CloneOf	string			`xml:"cloneof,attr"`
+	Parts	[]softwarePart		`xml:"part"`
+}
+
+type softwarePart struct {
+	Name		string			`xml:"name,attr"`
+	DataAreas	[]softwareDataArea	`xml:"dataarea"`
+	DiskAreas	[]softwareDiskArea	`xml:"diskarea"`
+}
+
+type softwareDataArea struct {
+	Name	string	`xml:"name,attr"`
+	ROMs	[]ROM	`xml:"rom"`
+}
+
+type softwareDiskArea struct {
+	Name	string	`xml:"name,attr"`
+	CHDs	[]CHD	`xml:"disk"`
+}
+
+// software lists by name
+var softwareLists = map[string]*Catalog{}
+
+func (s *software) game() *Game {
+	g := &Game{
+		Name:	s.Name,
+		CloneOf:	s.CloneOf,
+		ROMOf:	s.CloneOf,		// software parents are only ever clone parents
+	}
+	for _, p := range s.Parts {
+		for _, d := range p.DataAreas {
+			for _, r := range d.ROMs {
+				if r.Name == "" {		// continuation of the previous ROM (loadflag="continue", "fill", etc.), not a file of its own
+					continue
+				}
+				g.ROMs = append(g.ROMs, r)
+			}
+		}
+		for _, d := range p.DiskAreas {
+			g.CHDs = append(g.CHDs, d.CHDs...)
+		}
+	}
+	return g
+}
+
+func getSoftwareList(filename string, fstree *fuse.Tree) {
+	f, err := os.Open(filename)
+	if err != nil {
+		log.Fatalf("could not open software list %s: %v", filename, err)
+	}
+	defer f.Close()
+
+	var list softwareList
+	err = xml.NewDecoder(f).Decode(&list)
+	if err != nil {
+		log.Fatalf("error reading software list %s: %v", filename, err)
+	}
+	addSoftwareList(&list, filename, fstree)
+}
+
+func addSoftwareList(list *softwareList, filename string, fstree *fuse.Tree) {
+	if list.Name == "" {		// shouldn't happen, but MAME would use the filename too
+		list.Name = strings.TrimSuffix(filepath.Base(filename), filepath.Ext(filename))
+	}
+	c := &Catalog{
+		Games:	map[string]*Game{},
+		Dir:		list.Name,
+	}
+	for i := range list.Software {
+		g := list.Software[i].game()
+		c.Games[g.Name] = g
+	}
+	softwareLists[list.Name] = c
+	c.prepare(fstree)
+}
+
+// load every software list in the given directory (MAME's hash directory)
+func getSoftwareLists(dir string, fstree *fuse.Tree) {
+	files, err := filepath.Glob(filepath.Join(dir, "*.xml"))
+	if err != nil {
+		log.Fatalf("could not list software lists in %s: %v", dir, err)
+	}
+	n := 0
+	for _, f := range files {
+		getSoftwareList(f, fstree)
+		n++
+	}
+	log.Printf("loaded %d software lists from %s\n", n, dir)
+}
+
+// a game or piece of software named either "game" or "list/software"
+func findGame(name string) *Game {
+	if list, soft, ok := strings.Cut(name, "/"); ok {
+		c, ok := softwareLists[list]
+		if !ok {
+			return nil
+		}
+		return c.Games[soft]
+	}
+	return games[name]
+}
@@ -0,0 +1,354 @@
+// 18 october 2026
+package main
+
+import (
+	"fmt"
+	"io"
+	"bytes"
+	"sort"
+	"encoding/binary"
+	"unicode/utf8"
+)
+
+// when no single archive has everything a game needs, we build a zip out of the pieces we did find and serve that instead
+// the zip never exists anywhere; its layout (store method, fixed timestamps, entries sorted by name) is worked out up front so its size is known before anything is read and the same ROMs always give the same bytes
+// sizes and offsets that don't fit in the original 32-bit fields go in Zip64 extra fields, and the end record gets a Zip64 one in front of it, but only when needed so that small sets come out the same as before
+
+type synthEntry struct {
+	name	string
+	size		uint64
+	crc		uint32
+	src		romLocation
+}
+
+// a piece of the synthesized zip: either header bytes or the contents of an entry
+type synthPart struct {
+	offset	int64
+	size		int64
+	data		[]byte
+	entry	*synthEntry	// if data is nil
+}
+
+type synthZip struct {
+	entries	[]*synthEntry
+	parts	[]synthPart
+	size		int64
+}
+
+// DOS time and date of 24 december 1996 23:32, the same ones TorrentZip uses
+const (
+	synthTime	= 0xBC00
+	synthDate	= 0x2198
+)
+
+const (
+	zipLocalSig		= 0x04034B50
+	zipCentralSig		= 0x02014B50
+	zipEndSig		= 0x06054B50
+	zip64EndSig		= 0x06064B50
+	zip64LocatorSig	= 0x07064B50
+	zipVersion		= 10		// 1.0: stored files only
+	zip64Version		= 45		// 4.5: Zip64
+	zipUTF8Flag		= 0x800
+	zip64ExtraID		= 0x0001
+	zip32Max			= 0xFFFFFFFF		// in a 32-bit field, means look in the Zip64 extra field instead
+	zip16Max			= 0xFFFF
+)
+
+type zipLocalHeader struct {
+	Signature			uint32
+	Version			uint16
+	Flags			uint16
+	Method			uint16
+	Time				uint16
+	Date				uint16
+	CRC32			uint32
+	CompressedSize		uint32
+	UncompressedSize	uint32
+	NameLen			uint16
+	ExtraLen			uint16
+}
+
+type zipCentralHeader struct {
+	Signature			uint32
+	VersionMadeBy		uint16
+	Version			uint16
+	Flags			uint16
+	Method			uint16
+	Time				uint16
+	Date				uint16
+	CRC32			uint32
+	CompressedSize		uint32
+	UncompressedSize	uint32
+	NameLen			uint16
+	ExtraLen			uint16
+	CommentLen		uint16
+	DiskStart			uint16
+	InternalAttrs		uint16
+	ExternalAttrs		uint32
+	Offset			uint32
+}
+
+type zipEnd struct {
+	Signature			uint32
+	Disk				uint16
+	CentralDisk		uint16
+	DiskEntries		uint16
+	Entries			uint16
+	CentralSize		uint32
+	CentralOffset		uint32
+	CommentLen		uint16
+}
+
+type zip64End struct {
+	Signature			uint32
+	RecordSize		uint64		// not counting these first 12 bytes
+	VersionMadeBy		uint16
+	Version			uint16
+	Disk				uint32
+	CentralDisk		uint32
+	DiskEntries		uint64
+	Entries			uint64
+	CentralSize		uint64
+	CentralOffset		uint64
+}
+
+type zip64Locator struct {
+	Signature			uint32
+	EndDisk			uint32
+	EndOffset			uint64
+	Disks			uint32
+}
+
+// the Zip64 extra field holds only the values whose 32-bit fields are zip32Max, in this order
+func zip64Extra(values ...uint64) []byte {
+	if len(values) == 0 {
+		return nil
+	}
+	var b bytes.Buffer
+	binary.Write(&b, binary.LittleEndian, uint16(zip64ExtraID))
+	binary.Write(&b, binary.LittleEndian, uint16(8 * len(values)))
+	binary.Write(&b, binary.LittleEndian, values)
+	return b.Bytes()
+}
+
+func clamp32(v uint64) uint32 {
+	if v >= zip32Max {
+		return zip32Max
+	}
+	return uint32(v)
+}
+
+func nameFlags(name string) uint16 {
+	for i := 0; i < len(name); i++ {
+		if name[i] >= 0x80 && utf8.ValidString(name) {
+			return zipUTF8Flag
+		}
+	}
+	return 0
+}
+
+func (z *synthZip) addData(b []byte) {
+	z.parts = append(z.parts, synthPart{
+		offset:	z.size,
+		size:		int64(len(b)),
+		data:	b,
+	})
+	z.size += int64(len(b))
+}
+
+func (z *synthZip) addEntry(e *synthEntry) {
+	z.parts = append(z.parts, synthPart{
+		offset:	z.size,
+		size:		int64(e.size),
+		entry:	e,
+	})
+	z.size += int64(e.size)
+}
+
+func newSynthZip(entries []*synthEntry) *synthZip {
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].name < entries[j].name
+	})
+	z := &synthZip{
+		entries:	entries,
+	}
+	var central bytes.Buffer
+	for _, e := range entries {
+		offset := uint64(z.size)
+		version := uint16(zipVersion)
+		var localExtra, centralExtra []uint64
+		if e.size >= zip32Max {
+			localExtra = []uint64{e.size, e.size}
+			centralExtra = []uint64{e.size, e.size}
+		}
+		if offset >= zip32Max {
+			centralExtra = append(centralExtra, offset)
+		}
+		if len(centralExtra) != 0 {
+			version = zip64Version
+		}
+		var local bytes.Buffer
+		binary.Write(&local, binary.LittleEndian, zipLocalHeader{
+			Signature:			zipLocalSig,
+			Version:				version,
+			Flags:				nameFlags(e.name),
+			Time:				synthTime,
+			Date:				synthDate,
+			CRC32:				e.crc,
+			CompressedSize:		clamp32(e.size),
+			UncompressedSize:	clamp32(e.size),
+			NameLen:			uint16(len(e.name)),
+			ExtraLen:			uint16(len(zip64Extra(localExtra...))),
+		})
+		local.WriteString(e.name)
+		local.Write(zip64Extra(localExtra...))
+		binary.Write(&central, binary.LittleEndian, zipCentralHeader{
+			Signature:			zipCentralSig,
+			VersionMadeBy:		version,
+			Version:				version,
+			Flags:				nameFlags(e.name),
+			Time:				synthTime,
+			Date:				synthDate,
+			CRC32:				e.crc,
+			CompressedSize:		clamp32(e.size),
+			UncompressedSize:	clamp32(e.size),
+			NameLen:			uint16(len(e.name)),
+			ExtraLen:			uint16(len(zip64Extra(centralExtra...))),
+			Offset:				clamp32(offset),
+		})
+		central.WriteString(e.name)
+		central.Write(zip64Extra(centralExtra...))
+		z.addData(local.Bytes())
+		z.addEntry(e)
+	}
+	centralOffset := uint64(z.size)
+	centralSize := uint64(central.Len())
+	var end bytes.Buffer
+	if len(entries) >= zip16Max || centralSize >= zip32Max || centralOffset >= zip32Max {
+		end64Offset := centralOffset + centralSize
+		binary.Write(&end, binary.LittleEndian, zip64End{
+			Signature:		zip64EndSig,
+			RecordSize:		44,
+			VersionMadeBy:	zip64Version,
+			Version:			zip64Version,
+			DiskEntries:		uint64(len(entries)),
+			Entries:			uint64(len(entries)),
+			CentralSize:		centralSize,
+			CentralOffset:		centralOffset,
+		})
+		binary.Write(&end, binary.LittleEndian, zip64Locator{
+			Signature:		zip64LocatorSig,
+			EndOffset:		end64Offset,
+			Disks:			1,
+		})
+	}
+	entryCount := uint16(zip16Max)
+	if len(entries) < zip16Max {
+		entryCount = uint16(len(entries))
+	}
+	binary.Write(&end, binary.LittleEndian, zipEnd{
+		Signature:		zipEndSig,
+		DiskEntries:	entryCount,
+		Entries:		entryCount,
+		CentralSize:	clamp32(centralSize),
+		CentralOffset:	clamp32(centralOffset),
+	})
+	z.addData(central.Bytes())
+	z.addData(end.Bytes())
+	return z
+}
+
+// reads are served by streaming entries out of their source archives; we keep the entry we're in the middle of open since reads are almost always sequential
+type synthReader struct {
+	z		*synthZip
+	cur		*synthEntry
+	a		archive
+	r		io.ReadCloser
+	pos		int64		// within cur
+}
+
+func (z *synthZip) NewReader() *synthReader {
+	return &synthReader{
+		z:	z,
+	}
+}
+
+func (r *synthReader) ReadAt(p []byte, off int64) (n int, err error) {
+	parts := r.z.parts
+	i := sort.Search(len(parts), func(i int) bool {
+		return parts[i].offset + parts[i].size > off
+	})
+	for ; n < len(p) && i < len(parts); i++ {
+		part := parts[i]
+		partoff := off + int64(n) - part.offset
+		want := p[n:]
+		if int64(len(want)) > part.size - partoff {
+			want = want[:part.size - partoff]
+		}
+		if part.data != nil {
+			n += copy(want, part.data[partoff:])
+			continue
+		}
+		c, err := r.readEntry(part.entry, partoff, want)
+		n += c
+		if err != nil {
+			return n, fmt.Errorf("could not read %s from %s: %v", part.entry.src.entry, part.entry.src.archive, err)
+		}
+	}
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+func (r *synthReader) readEntry(e *synthEntry, off int64, p []byte) (int, error) {
+	if r.cur != e || off < r.pos {		// have to start over
+		r.Close()
+		err := r.open(e)
+		if err != nil {
+			return 0, err
+		}
+	}
+	if off > r.pos {
+		_, err := io.CopyN(io.Discard, r.r, off - r.pos)
+		if err != nil {
+			r.Close()
+			return 0, err
+		}
+		r.pos = off
+	}
+	n, err := io.ReadFull(r.r, p)
+	r.pos += int64(n)
+	if err != nil {
+		r.Close()
+	}
+	return n, err
+}
+
+func (r *synthReader) open(e *synthEntry) error {
+	a, f, err := e.src.open()
+	if err != nil {
+		return err
+	}
+	r.r, err = f.Open()
+	if err != nil {
+		a.Close()
+		return err
+	}
+	r.a = a
+	r.cur = e
+	r.pos = 0
+	return nil
+}
+
+func (r *synthReader) Close() error {
+	if r.r != nil {
+		r.r.Close()
+		r.a.Close()
+	}
+	r.cur = nil
+	r.a = nil
+	r.r = nil
+	return nil
+}
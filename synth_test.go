@@ -0,0 +1,170 @@
+// 18 october 2026
+package main
+
+import (
+	"testing"
+	"os"
+	"io"
+	"bytes"
+	"fmt"
+	"hash/crc32"
+	"archive/zip"
+	"compress/flate"
+	"path/filepath"
+)
+
+// a zip with a small entry, and one archive/zip writes Zip64 extra fields for because its header says it's 5 GiB
+// CreateRaw() takes the sizes on trust, so the file itself stays small; nothing here reads the big entry's data
+const bigSize = 5 << 30		// 1 << 30 if cut to 32 bits
+
+func writeTestZip(t *testing.T, filename string, small []byte) {
+	f, err := os.Create(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	w := zip.NewWriter(f)
+	fw, err := w.Create("small.bin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fw.Write(small)
+	var deflated bytes.Buffer
+	fl, _ := flate.NewWriter(&deflated, flate.BestCompression)
+	fl.Close()
+	fw, err = w.CreateRaw(&zip.FileHeader{
+		Name:				"big.bin",
+		Method:				zip.Deflate,
+		CRC32:				0x12345678,
+		CompressedSize64:		uint64(deflated.Len()),
+		UncompressedSize64:	bigSize,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	fw.Write(deflated.Bytes())
+	err = w.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestZip64Archive(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "zip64.zip")
+	writeTestZip(t, filename, []byte("small"))
+	a, err := openArchive(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer a.Close()
+	var big archiveFile
+	for _, f := range a.Files() {
+		if f.Name() == "big.bin" {
+			big = f
+		}
+	}
+	if big == nil {
+		t.Fatal("big.bin not in the archive")
+	}
+	if big.Size() != bigSize {
+		t.Errorf("size is %d, want %d", big.Size(), uint64(bigSize))
+	}
+	for _, tc := range []struct {
+		size		uint64
+		want	auditState
+	}{
+		{bigSize, stateOK},
+		{bigSize & 0xFFFFFFFF, stateWrongSize},		// what a 32-bit size would have matched
+	} {
+		rom := &ROM{
+			Name:	"big.bin",
+			Size:		tc.size,
+			CRC32:	"12345678",
+		}
+		state, err := romState(big, rom)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if state != tc.want {
+			t.Errorf("ROM of size %d is %s, want %s", tc.size, stateNames[state], stateNames[tc.want])
+		}
+	}
+}
+
+// a synthesized zip with an entry too big for 32 bits, and one after it at an offset too big for 32 bits, read back with archive/zip
+// the big entry's source is never read; the small one is read out of a real zip, and is big enough that archive/zip looking for the end of the central directory doesn't reach back into the big one
+func TestSynthZip64(t *testing.T) {
+	small := bytes.Repeat([]byte("the small entry comes after 5 GiB of the big one\n"), 2000)
+	src := filepath.Join(t.TempDir(), "src.zip")
+	writeTestZip(t, src, small)
+	z := newSynthZip([]*synthEntry{
+		&synthEntry{
+			name:	"a.bin",
+			size:		bigSize,
+			crc:		0x12345678,
+			src:		romLocation{src, "big.bin"},
+		},
+		&synthEntry{
+			name:	"b.bin",
+			size:		uint64(len(small)),
+			crc:		crc32.ChecksumIEEE(small),
+			src:		romLocation{src, "small.bin"},
+		},
+	})
+	if z.size <= bigSize {
+		t.Fatalf("synthesized zip is only %d bytes", z.size)
+	}
+	r := z.NewReader()
+	defer r.Close()
+	zr, err := zip.NewReader(r, z.size)
+	if err != nil {
+		t.Fatalf("archive/zip can't read the synthesized zip: %v", err)
+	}
+	if len(zr.File) != 2 {
+		t.Fatalf("%d entries, want 2", len(zr.File))
+	}
+	a, b := zr.File[0], zr.File[1]
+	if a.Name != "a.bin" || a.UncompressedSize64 != bigSize || a.CompressedSize64 != bigSize || a.CRC32 != 0x12345678 || a.Method != zip.Store {
+		t.Errorf("wrong big entry %+v", a.FileHeader)
+	}
+	off, err := b.DataOffset()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if off <= bigSize {
+		t.Errorf("small entry's data is at %d, which doesn't need Zip64", off)
+	}
+	rc, err := b.Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := io.ReadAll(rc)		// also checks the CRC-32
+	rc.Close()
+	if err != nil {
+		t.Fatalf("could not read the small entry: %v", err)
+	}
+	if !bytes.Equal(got, small) {
+		t.Errorf("small entry doesn't match what went in")
+	}
+}
+
+// sets that don't need Zip64 shouldn't get any of it
+func TestSynthNoZip64(t *testing.T) {
+	var entries []*synthEntry
+	for i := 0; i < 3; i++ {
+		entries = append(entries, &synthEntry{
+			name:	fmt.Sprintf("%d.bin", i),
+			size:		1000,
+		})
+	}
+	z := newSynthZip(entries)
+	end := z.parts[len(z.parts) - 1].data
+	if len(end) != 22 {
+		t.Errorf("end of central directory is %d bytes, want just the 22-byte 32-bit one", len(end))
+	}
+	for _, p := range z.parts {
+		if p.data != nil && bytes.Contains(p.data, []byte{0x01, 0x00, 0x10, 0x00}) {		// a Zip64 extra field
+			t.Errorf("Zip64 extra field in a small zip")
+		}
+	}
+}
@@ -0,0 +1,355 @@
+# makes the small CHDs chd_test.go decodes
+# run it in this directory: python3 mkchd.py
+# it prints the SHA-1 of each one's data, worked out here from what went in and not by decoding, for the table in chd_test.go
+# the compressors here are written separately from the Go decoders, from the formats' specs
+import struct, zlib, lzma, hashlib, random, math
+random.seed(7)
+
+class BW:
+    def __init__(s): s.bits=[]
+    def w(s, v, n):
+        for i in range(n-1,-1,-1): s.bits.append((v>>i)&1)
+    def align(s):
+        while len(s.bits)%8: s.bits.append(0)
+    def bytes(s):
+        b=list(s.bits)
+        while len(b)%8: b.append(0)
+        return bytes(int(''.join(map(str,b[i:i+8])),2) for i in range(0,len(b),8))
+
+def crc16ccitt(d):
+    c=0xffff
+    for x in d:
+        c^=x<<8
+        for _ in range(8):
+            c=((c<<1)^0x1021)&0xffff if c&0x8000 else (c<<1)&0xffff
+    return c
+def crc16flac(d):
+    c=0
+    for x in d:
+        c^=x<<8
+        for _ in range(8):
+            c=((c<<1)^0x8005)&0xffff if c&0x8000 else (c<<1)&0xffff
+    return c
+def crc8(d):
+    c=0
+    for x in d:
+        c^=x
+        for _ in range(8):
+            c=((c<<1)^0x07)&0xff if c&0x80 else (c<<1)&0xff
+    return c
+
+def rnd(n): return bytes(random.getrandbits(8) for _ in range(n))
+
+# ---- codecs
+
+def deflate(d):
+    o=zlib.compressobj(9, zlib.DEFLATED, -15); return o.compress(d)+o.flush()
+
+def lz(d):
+    return lzma.compress(d, format=lzma.FORMAT_RAW, filters=[{"id": lzma.FILTER_LZMA1,"lc":3,"lp":0,"pb":2,"dict_size":1<<16}])
+
+# a Huffman tree where every byte is 8 bits long, written with the small tree and an RLE run
+def huff(d):
+    bw=BW()
+    bw.w(1,3)      # small tree symbol 0 (rle) has length 1
+    bw.w(7,3)      # start=8
+    bw.w(0,3)      # index 8: 0
+    bw.w(1,3)      # index 9: 1
+    bw.w(7,3)      # terminate
+    bw.w(1,1)      # symbol 9 -> length 8 for code 0
+    bw.w(0,1); bw.w(7,3); bw.w(246,8)  # rle 255 more
+    for x in d: bw.w(x,8)
+    return bw.bytes()
+
+def rice(bw, vals, param, method):
+    for v in vals:
+        u = 2*v if v>=0 else -2*v-1
+        q=u>>param
+        for _ in range(q): bw.w(0,1)
+        bw.w(1,1); bw.w(u&((1<<param)-1), param)
+
+# FLAC frames cycling through mid/side with fixed and LPC subframes, left/side with a verbatim subframe with a wasted bit and a constant one, and left/side with escaped rice partitions
+def flac_frames(samples_l, samples_r, bs):
+    out=b''
+    fn=0
+    for st in range(0,len(samples_l),bs):
+        L=samples_l[st:st+bs]; R=samples_r[st:st+bs]; n=len(L)
+        kind = fn % 3
+        bw=BW()
+        bw.w(0x3FFE,14); bw.w(0,1); bw.w(0,1)
+        bw.w(7,4); bw.w(9,4)
+        bw.w([10,1,8][kind],4); bw.w(4,3); bw.w(0,1)
+        assert fn<128; bw.w(fn,8)
+        bw.w(n-1,16)
+        hb=bw.bytes(); bw.w(crc8(hb),8)
+        def sub_fixed2(s, bps, method, porder):
+            bw.w(0,1); bw.w(8+2,6); bw.w(0,1)
+            bw.w(s[0]&((1<<bps)-1),bps); bw.w(s[1]&((1<<bps)-1),bps)
+            res=[s[i]-2*s[i-1]+s[i-2] for i in range(2,len(s))]
+            bw.w(method,2); bw.w(porder,4)
+            ps=len(s)>>porder; idx=0
+            for p in range(1<<porder):
+                cnt = ps-2 if p==0 else ps
+                part=res[idx:idx+cnt]; idx+=cnt
+                if p==1:   # escape
+                    bw.w(15 if method==0 else 31, 4 if method==0 else 5); bw.w(20,5)
+                    for v in part: bw.w(v&((1<<20)-1),20)
+                else:
+                    bw.w(5, 4 if method==0 else 5); rice(bw, part, 5, method)
+        def sub_lpc2(s,bps):
+            bw.w(0,1); bw.w(32+1,6); bw.w(0,1)
+            bw.w(s[0]&((1<<bps)-1),bps); bw.w(s[1]&((1<<bps)-1),bps)
+            bw.w(3-1,4); bw.w(0,5); bw.w(2&7,3); bw.w((-1)&7,3)
+            res=[s[i]-2*s[i-1]+s[i-2] for i in range(2,len(s))]
+            bw.w(1,2); bw.w(0,4); bw.w(6,5); rice(bw,res,6,1)
+        def sub_verb_wasted(s,bps):
+            bw.w(0,1); bw.w(1,6); bw.w(1,1); bw.w(1,1)  # unary k=0 -> wasted 1
+            for v in s: bw.w((v>>1)&((1<<(bps-1))-1),bps-1)
+        def sub_const(v,bps):
+            bw.w(0,1); bw.w(0,6); bw.w(0,1); bw.w(v&((1<<bps)-1),bps)
+        if kind==0:
+            mid=[(l+r)>>1 for l,r in zip(L,R)]; side=[l-r for l,r in zip(L,R)]
+            sub_fixed2(mid,16,0,1); sub_lpc2(side,17)
+        elif kind==1:
+            sub_verb_wasted(L,16); sub_const(R[0],16)
+        else:
+            side=[l-r for l,r in zip(L,R)]
+            sub_fixed2(L,16,1,0); sub_fixed2(side,17,0,1)
+        bw.align()
+        fb=bw.bytes()
+        out+=fb+struct.pack('>H',crc16flac(fb))
+        fn+=1
+    return out
+
+# stereo samples; frames of the second kind above need an even left channel and a constant right one
+def audio(nsamp, bs):
+    L=[int(8000*math.sin(i/20.0))+random.randint(-50,50) for i in range(nsamp)]
+    R=[int(6000*math.cos(i/17.0))+random.randint(-50,50) for i in range(nsamp)]
+    for fn,st in enumerate(range(0,nsamp,bs)):
+        if fn%3==1:
+            for i in range(st,min(st+bs,nsamp)):
+                L[i]&=~1; R[i]=R[st]
+    return L,R
+
+def pcm(L,R,big):
+    return b''.join(struct.pack('>hh' if big else '<hh', l, r) for l,r in zip(L,R))
+
+# ---- CD sectors, with ECC the way ECM works it out, as a cross-check of cdrom.go
+
+flut=[0]*256; blut=[0]*256
+for i in range(256):
+    j=(i<<1)^(0x11D if i&0x80 else 0); flut[i]=j&0xff; blut[i^flut[i]]=i
+def ecc_block(src, major_count, minor_count, major_mult, minor_inc):
+    size=major_count*minor_count; dest=[0]*(2*major_count)
+    for major in range(major_count):
+        index=(major>>1)*major_mult+(major&1); a=b=0
+        for minor in range(minor_count):
+            t=src[index]; index+=minor_inc
+            if index>=size: index-=size
+            a^=t; b^=t; a=flut[a]
+        a=blut[flut[a]^b]; dest[major]=a; dest[major+major_count]=a^b
+    return dest
+
+# mode 2 sectors work out their ECC as if the header were zero
+def add_ecc(s):
+    t=bytearray(s[12:])
+    if s[15]==2: t[0:4]=bytes(4)
+    p=ecc_block(t, 86, 24, 2, 86); s[0x81C:0x81C+172]=bytes(p); t[0x81C-12:0x81C-12+172]=bytes(p)
+    q=ecc_block(t, 52, 43, 86, 88); s[0x8C8:0x8C8+104]=bytes(q)
+
+def data_sector(lba, mode):
+    s=bytearray(2352)
+    s[0:12]=bytes([0]+[0xff]*10+[0])
+    s[12:15]=bytes([lba%100, lba%60, lba%75]); s[15]=mode
+    if mode==1:
+        s[16:16+2048]=rnd(2048)
+        s[0x810:0x814]=b'EDC!'
+    else:   # form 1: subheader twice, then the data
+        s[16:24]=bytes([0,0,8,0])*2
+        s[24:24+2048]=rnd(2048)
+        s[0x818:0x81C]=b'EDC!'
+    add_ecc(s)
+    return bytes(s)
+
+# ---- containers
+
+# hunks: ('c', codec, data, compressed), ('none', data), ('self', n), or ('self0', n) (self, written as the type for "the hunk just before")
+def write_v5(fname, compressors, hunkbytes, unitbytes, hunks, meta):
+    logical = hunkbytes*len(hunks)
+    data=bytearray(b'\0'*124)
+    types=[]
+    firstoffs=len(data)
+    entries=[]
+    decomp=[]
+    for h in hunks:
+        if h[0]=='c':
+            _,idx,d,c=h; off=len(data); data+=c
+            types.append(idx); entries.append((idx,len(c),off,crc16ccitt(d))); decomp.append(d)
+        elif h[0]=='none':
+            d=h[1]; off=len(data); data+=d
+            types.append(4); entries.append((4,hunkbytes,off,crc16ccitt(d))); decomp.append(d)
+        elif h[0]=='self':
+            types.append(5); entries.append((5,0,h[1],0)); decomp.append(decomp[h[1]])
+        elif h[0]=='self0':
+            types.append(9); entries.append((5,0,h[1],0)); decomp.append(decomp[h[1]])
+    raw=b''.join(decomp)[:logical]
+    rawsha1=hashlib.sha1(raw).digest()
+    maxlen=max([e[1] for e in entries if e[0]<4] or [1])
+    lengthbits=max(1,maxlen.bit_length()); selfbits=max(1,len(hunks).bit_length()); parentbits=1
+    bw=BW()
+    for _ in range(16): bw.w(4,4)
+    # types, with RLE where the same compressed type repeats 3 or more times
+    i=0; last=0
+    while i<len(types):
+        t=types[i]
+        run=1
+        while i+run<len(types) and types[i+run]==t and t<4: run+=1
+        if t==last and run>=3 and t<4:
+            rep=min(run-1, 2+15)
+            bw.w(7,4); bw.w(rep-2,4); i+=rep+1
+            continue
+        bw.w(t,4); last=t; i+=1
+    rawmap=b''
+    for (t,l,o,c),tt in zip(entries,types):
+        if t<4: bw.w(l,lengthbits); bw.w(c,16)
+        elif t==4: bw.w(c,16)
+        elif tt==5: bw.w(o,selfbits)
+        rawmap+=bytes([t])+l.to_bytes(3,'big')+o.to_bytes(6,'big')+c.to_bytes(2,'big')
+    mapc=bw.bytes()
+    mapoff=len(data)
+    data+=struct.pack('>I',len(mapc))+firstoffs.to_bytes(6,'big')+struct.pack('>HBBBB',crc16ccitt(rawmap),lengthbits,selfbits,parentbits,0)+mapc
+    metaoff=write_meta(data, meta)
+    sha1=overall_sha1(rawsha1, meta)
+    hdr=b'MComprHD'+struct.pack('>II',124,5)+b''.join(struct.pack('>4s',c) if c else b'\0\0\0\0' for c in compressors)
+    hdr+=struct.pack('>QQQII',logical,mapoff,metaoff,hunkbytes,unitbytes)+rawsha1+sha1+b'\0'*20
+    data[0:124]=hdr
+    open(fname,'wb').write(data)
+    return raw
+
+# v5 without compression: the map is just where each hunk is, in hunks; 0 is all zeroes
+def write_v5_raw(fname, hunkbytes, hunks):
+    n=len(hunks)
+    data=bytearray(124+4*n)
+    while len(data)%hunkbytes: data.append(0)
+    mp=[]
+    for d in hunks:
+        if d==bytes(hunkbytes):
+            mp.append(0)
+        else:
+            mp.append(len(data)//hunkbytes); data+=d
+    data[124:124+4*n]=b''.join(struct.pack('>I',x) for x in mp)
+    raw=b''.join(hunks); rawsha1=hashlib.sha1(raw).digest(); sha1=hashlib.sha1(rawsha1).digest()
+    data[0:124]=b'MComprHD'+struct.pack('>II',124,5)+b'\0'*16+struct.pack('>QQQII',len(raw),124,0,hunkbytes,512)+rawsha1+sha1+b'\0'*20
+    open(fname,'wb').write(data)
+    return raw
+
+# metadata is linked newest first, so the first item ends up at the end of the list
+def write_meta(data, meta):
+    metaoff=0
+    for tag,flags,md in meta:
+        off=len(data)
+        data+=struct.pack('>4sIQ',tag,(flags<<24)|len(md),metaoff)+md
+        metaoff=off
+    return metaoff
+
+def overall_sha1(rawsha1, meta):
+    items=sorted(tag+hashlib.sha1(md).digest() for tag,flags,md in meta if flags&1)
+    return hashlib.sha1(rawsha1+b''.join(items)).digest()
+
+# v3 and v4 have the same map: zlib, uncompressed, and all-zero ("mini") hunks, taking turns
+def write_v34(fname, version, hunkbytes, hunks, meta=[]):
+    nh=len(hunks); hl={3: 120, 4: 108}[version]
+    data=bytearray(b'\0'*hl)+b'\0'*(16*nh)+b'EndOfListCookie\0'
+    mp=b''
+    for i,d in enumerate(hunks):
+        if d==bytes(hunkbytes):
+            mp+=struct.pack('>QIHBB',0,zlib.crc32(d),0,0,3)
+        elif i%2==0:
+            c=deflate(d); off=len(data); data+=c
+            mp+=struct.pack('>QIHBB',off,zlib.crc32(d),len(c)&0xffff,len(c)>>16,1)
+        else:
+            off=len(data); data+=d
+            mp+=struct.pack('>QIHBB',off,zlib.crc32(d),hunkbytes&0xffff,hunkbytes>>16,2)
+    data[hl:hl+16*nh]=mp
+    metaoff=write_meta(data, meta)
+    raw=b''.join(hunks); rawsha1=hashlib.sha1(raw).digest()
+    if version==3:
+        sha1=rawsha1
+        hdr=b'MComprHD'+struct.pack('>IIIIIQQ',hl,3,0,1,nh,len(raw),metaoff)+hashlib.md5(raw).digest()+b'\0'*16+struct.pack('>I',hunkbytes)+sha1+b'\0'*20
+    else:
+        sha1=overall_sha1(rawsha1, meta)
+        hdr=b'MComprHD'+struct.pack('>IIIIIQQI',hl,4,0,1,nh,len(raw),metaoff,hunkbytes)+sha1+b'\0'*20+rawsha1
+    data[0:hl]=hdr
+    open(fname,'wb').write(data)
+    return raw
+
+# ---- the files
+# only when run, so mkchdman.py can use the sector maker
+
+if __name__ == '__main__':
+    sums={}
+    def done(name, raw):
+        sums[name]=hashlib.sha1(raw).hexdigest()
+
+    HB=4096
+    def text(k): return (('block %d '%k).encode()*600)[:HB]
+
+    # each codec on its own, with some uncompressed and self hunks mixed in
+    done('zlib.chd', write_v5('zlib.chd',[b'zlib',None,None,None],HB,512,
+        [('c',0,text(k),deflate(text(k))) for k in range(5)]+[('none',rnd(HB)),('self',1),('self0',1)],
+        [(b'GDDD',1,b'CYLS:1,HEADS:1,SECS:16,BPS:512\0'),(b'XXXX',0,b'not checksummed')]))
+    d=(bytes(range(256))*16)[:HB]
+    done('lzma.chd', write_v5('lzma.chd',[b'lzma',None,None,None],HB,512,
+        [('c',0,d,lz(d))]+[('c',0,text(k),lz(text(k))) for k in range(4)],
+        [(b'GDDD',1,b'CYLS:1,HEADS:1,SECS:8,BPS:512\0')]))
+    hunks=[]
+    for k in range(2):
+        d=bytes(random.choice(b'ABCD') for _ in range(HB)); hunks.append(('c',0,d,huff(d)))
+    done('huff.chd', write_v5('huff.chd',[b'huff',None,None,None],HB,512,hunks,[]))
+    hunks=[]
+    for k in range(2):
+        L,R=audio(HB//4, HB//16); d=pcm(L,R,False); hunks.append(('c',0,d,b'L'+flac_frames(L,R,HB//16)))
+    done('flac.chd', write_v5('flac.chd',[b'flac',None,None,None],HB,512,hunks,[]))
+
+    done('v5raw.chd', write_v5_raw('v5raw.chd',1024,[rnd(1024),bytes(1024),b'y'*1024]))
+    done('v4.chd', write_v34('v4.chd',4,1024,[rnd(1024),b'x'*1024,bytes(1024),rnd(1024)]))
+    done('v3.chd', write_v34('v3.chd',3,1024,[b'z'*1024,rnd(1024),bytes(1024),rnd(1024),rnd(1024)]))
+
+    # CDs: 8 frames a hunk; the sync header and ECC are stripped from every data sector but one, and have to be made again
+    FR=8; CHB=FR*2448
+    def cd_hunk(base, sub, mode, lba0):
+        bitmap=bytearray((FR+7)//8); secs=b''; subs=b''; full=b''
+        for f in range(FR):
+            s=data_sector(lba0+f, mode); sc=rnd(96)
+            full+=s+sc
+            stripped=bytearray(s)
+            if f!=3:
+                bitmap[f//8]|=1<<(f%8)
+                stripped[0:12]=b'\0'*12; stripped[0x81C:0x81C+276]=b'\0'*276
+            secs+=bytes(stripped); subs+=sc
+        cb=base(secs); header=bytes(bitmap)+len(cb).to_bytes(2,'big')
+        return full, header+cb+sub(subs)
+    for mode in (1, 2):
+        cdh=[]
+        for h,codec,base in ((0,0,deflate),(1,1,lz)):
+            full,c=cd_hunk(base,deflate,mode,8*h); cdh.append(('c',codec,full,c))
+        typ=b'MODE1_RAW' if mode==1 else b'MODE2_RAW'
+        done('cdmode%d.chd'%mode, write_v5('cdmode%d.chd'%mode,[b'cdzl',b'cdlz',None,None],CHB,2448,cdh,[(b'CHT2',1,b'TRACK:1 TYPE:'+typ+b' SUBTYPE:RW FRAMES:16\0')]))
+    L,R=audio(FR*588, 2352); sec=pcm(L,R,True); subs=rnd(FR*96)
+    full=b''.join(sec[f*2352:(f+1)*2352]+subs[f*96:(f+1)*96] for f in range(FR))
+    done('cdaudio.chd', write_v5('cdaudio.chd',[b'cdfl',None,None,None],CHB,2448,[('c',0,full,flac_frames(L,R,2352)+deflate(subs))],[(b'CHT2',1,b'TRACK:1 TYPE:AUDIO SUBTYPE:RW FRAMES:8\0')]))
+
+    # a v3 CD with the binary CHCD table of contents, in little-endian order like chdman on x86 wrote it: a mode 1 track (just the 2048 bytes of data in each frame) padded to a hunk boundary, then an audio one
+    # for raw_test.go, the SHA-1 of what the raw view should give is printed too
+    frames=[rnd(2048)+bytes(304)+rnd(96) for f in range(10)]+[bytes(2448)]*6
+    frames+=[rnd(2352)+rnd(96) for f in range(8)]
+    toc=struct.pack('<I',2)+struct.pack('<6I',0,0,2048,96,10,6)+struct.pack('<6I',7,0,2352,96,8,0)
+    toc+=bytes(4+99*24-len(toc))
+    write_v34('cdv3.chd',3,CHB,[b''.join(frames[h*8:(h+1)*8]) for h in range(3)],[(b'CHCD',0,toc)])
+    img=b''.join(fr[:2048] for fr in frames[:10])
+    for fr in frames[16:]:
+        img+=b''.join(fr[i+1:i+2]+fr[i:i+1] for i in range(0,2352,2))
+    print('cdv3.chd raw view:', hashlib.sha1(img).hexdigest(), len(img))
+
+    for name in sorted(sums):
+        print('\t{"%s",%s"%s"},' % (name, '\t'*(2 if len(name)<10 else 1), sums[name]))
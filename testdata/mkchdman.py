@@ -0,0 +1,38 @@
+# makes the chdman-*.chd files chd_test.go checks our decoders against
+# mkchd.py's encoders were written from the same reading of the format as the Go decoders, so those fixtures can't catch a misreading they share; these come from chdman itself
+# needs chdman from MAME; run it in this directory: python3 mkchdman.py
+# the inputs are made here and thrown away; it prints the SHA-1 of each input, which is what the raw view of each CHD has to give, for the table in chd_test.go
+import hashlib, os, random, shutil, subprocess, sys, tempfile
+import mkchd
+random.seed(11)
+rnd=mkchd.rnd
+
+tmp=tempfile.mkdtemp()
+sums={}
+
+# a hard disk with hunks lzma, huff, and neither will do well on
+HB=4096
+hd=b''
+for k in range(4): hd+=(('sector %d of a hard disk '%k).encode()*200)[:HB]
+for k in range(4): hd+=bytes(random.choice(b'ABCD') for _ in range(HB))
+hd+=rnd(2*HB)
+open(os.path.join(tmp,'hd.raw'),'wb').write(hd)
+sums['chdman-hd.chd']=hashlib.sha1(hd).hexdigest()
+
+# a CD with a mode 1 track of whole sectors (so chdman strips their ECC) and then an audio track, neither a multiple of chdman's 4-frame padding
+data=b''.join(mkchd.data_sector(lba,1) for lba in range(10))
+audio=rnd(8*2352)
+open(os.path.join(tmp,'cd.bin'),'wb').write(data+audio)
+open(os.path.join(tmp,'cd.cue'),'w').write('FILE "cd.bin" BINARY\n  TRACK 01 MODE1/2352\n    INDEX 01 00:00:00\n  TRACK 02 AUDIO\n    INDEX 01 00:00:10\n')
+sums['chdman-cd.chd']=hashlib.sha1(data+audio).hexdigest()
+
+for name in sorted(sums):
+    print('\t{"%s",\t"%s"},' % (name, sums[name]))
+
+try:
+    subprocess.run(['chdman','createhd','-f','-i',os.path.join(tmp,'hd.raw'),'-o','chdman-hd.chd','-c','lzma,huff','-hs',str(HB)],check=True)
+    subprocess.run(['chdman','createcd','-f','-i',os.path.join(tmp,'cd.cue'),'-o','chdman-cd.chd','-c','cdlz,cdfl'],check=True)
+except FileNotFoundError:
+    sys.exit('chdman not found')
+finally:
+    shutil.rmtree(tmp)